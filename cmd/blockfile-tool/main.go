@@ -0,0 +1,281 @@
+// Command blockfile-tool inspects and repairs the block file of a node.
+//
+//	blockfile-tool info <dir>
+//	blockfile-tool verify <dir>
+//	blockfile-tool dump [-raw] <dir> <number>
+//	blockfile-tool truncate <dir> <height>
+//
+// info only reads the files, so it works on the block files failing to open. verify and
+// dump open the block file read-only, so they work on the block file of a running node,
+// verify ignores the data past the committed blocks, which is the append in progress.
+// truncate opens the block file for writing, which repairs it, and needs the node stopped.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/axiomesh/axiom-kit/log"
+	"github.com/axiomesh/axiom-kit/storage/blockfile"
+	"github.com/axiomesh/axiom-kit/types"
+)
+
+const usage = `usage: blockfile-tool <command> [flags] <dir> [args]
+
+commands:
+  info      print the items, the tail and the files of every table
+  verify    check the index and data files, and the checksums of every item
+  dump      print a block, decoded or raw with -raw
+  truncate  drop the blocks above the given height
+`
+
+var errProblems = errors.New("problems found")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "info":
+		return info(args[1:], w)
+	case "verify":
+		return verify(args[1:], w)
+	case "dump":
+		return dump(args[1:], w)
+	case "truncate":
+		return truncate(args[1:], w)
+	default:
+		return errors.Errorf("unknown command %s\n%s", args[0], usage)
+	}
+}
+
+// parse parses the flags and checks the number of the positional arguments.
+func parse(fs *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != len(positional) {
+		return nil, errors.Errorf("usage: blockfile-tool %s [flags] <%s>", fs.Name(), strings.Join(positional, "> <"))
+	}
+	return fs.Args(), nil
+}
+
+func info(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	positional, err := parse(fs, args, "dir")
+	if err != nil {
+		return err
+	}
+	inspected, err := blockfile.Inspect(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if inspected.Committed == math.MaxUint64 {
+		fmt.Fprintln(w, "committed: no commit marker")
+	} else {
+		fmt.Fprintf(w, "committed: %d\n", inspected.Committed)
+	}
+	fmt.Fprintf(w, "tail:      %d\n", inspected.Tail)
+	for _, table := range inspected.Tables {
+		fmt.Fprintf(w, "\ntable %s\n", table.Name)
+		fmt.Fprintf(w, "  index:     v%d, %d bytes\n", table.IndexVersion, table.IndexSize)
+		fmt.Fprintf(w, "  items:     %d, %d pruned\n", table.Items, table.Tail)
+		fmt.Fprintf(w, "  checksums: %d\n", table.Checksums)
+		fmt.Fprintf(w, "  files:     tail %d, head %d\n", table.TailFile, table.HeadFile)
+		var nums []int
+		for num := range table.DataFiles {
+			nums = append(nums, int(num))
+		}
+		sort.Ints(nums)
+		for _, num := range nums {
+			fmt.Fprintf(w, "    %04d: %d bytes\n", num, table.DataFiles[uint32(num)])
+		}
+		for _, problem := range table.Problems {
+			fmt.Fprintf(w, "  problem:   %s\n", problem)
+		}
+		for _, problem := range table.Uncommitted {
+			fmt.Fprintf(w, "  uncommitted: %s\n", problem)
+		}
+	}
+	return nil
+}
+
+func verify(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	positional, err := parse(fs, args, "dir")
+	if err != nil {
+		return err
+	}
+	inspected, err := blockfile.Inspect(positional[0])
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, table := range inspected.Tables {
+		for _, problem := range table.Problems {
+			fmt.Fprintf(w, "%s: %s\n", table.Name, problem)
+			problems++
+		}
+		if inspected.Committed != math.MaxUint64 && table.Items < inspected.Committed {
+			fmt.Fprintf(w, "%s: %d items for %d committed blocks\n", table.Name, table.Items, inspected.Committed)
+			problems++
+		}
+	}
+	if problems > 0 {
+		// the checksums can only be verified on a block file that opens
+		return errors.Wrapf(errProblems, "%d index and data problems", problems)
+	}
+
+	bf, err := blockfile.OpenReadOnly(positional[0], log.NewWithModule("blockfile-tool"))
+	if err != nil {
+		return err
+	}
+	defer bf.Close()
+	corrupted, err := bf.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, table := range inspected.Tables {
+		for _, number := range corrupted[table.Name] {
+			fmt.Fprintf(w, "%s: item %d fails its checksum\n", table.Name, number)
+			problems++
+		}
+	}
+	if problems > 0 {
+		return errors.Wrapf(errProblems, "%d corrupted items", problems)
+	}
+	fmt.Fprintf(w, "ok: %d blocks in %d tables\n", bf.NextBlockNumber(), len(inspected.Tables))
+	return nil
+}
+
+func dump(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "print the raw items in hex")
+	positional, err := parse(fs, args, "dir", "number")
+	if err != nil {
+		return err
+	}
+	number, err := strconv.ParseUint(positional[1], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid block number")
+	}
+	inspected, err := blockfile.Inspect(positional[0])
+	if err != nil {
+		return err
+	}
+	bf, err := blockfile.OpenReadOnly(positional[0], log.NewWithModule("blockfile-tool"))
+	if err != nil {
+		return err
+	}
+	defer bf.Close()
+
+	out := make(map[string]any)
+	for _, table := range inspected.Tables {
+		item, err := bf.Get(table.Name, number)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get block %d from table %s", number, table.Name)
+		}
+		if *raw {
+			out[table.Name] = hex.EncodeToString(item)
+			continue
+		}
+		if out[table.Name], err = decode(table.Name, item); err != nil {
+			return errors.Wrapf(err, "failed to decode block %d from table %s", number, table.Name)
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// decode decodes the items of the tables of BlockFileSchema, the other ones are printed in hex.
+func decode(table string, item []byte) (any, error) {
+	switch table {
+	case blockfile.BlockFileHeaderTable:
+		header := &types.BlockHeader{}
+		if err := header.Unmarshal(item); err != nil {
+			return nil, err
+		}
+		return header, nil
+	case blockfile.BlockFileExtraTable:
+		if len(item) == 0 {
+			return nil, nil
+		}
+		extra := &types.BlockExtra{}
+		if err := extra.Unmarshal(item); err != nil {
+			return nil, err
+		}
+		return extra, nil
+	case blockfile.BlockFileTXsTable:
+		return types.UnmarshalTransactions(item)
+	case blockfile.BlockFileReceiptsTable:
+		return types.UnmarshalReceipts(item)
+	case blockfile.BlockFileHashTable:
+		return types.NewHash(item), nil
+	default:
+		return hex.EncodeToString(item), nil
+	}
+}
+
+func truncate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("truncate", flag.ContinueOnError)
+	positional, err := parse(fs, args, "dir", "height")
+	if err != nil {
+		return err
+	}
+	height, err := strconv.ParseUint(positional[1], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid height")
+	}
+	inspected, err := blockfile.Inspect(positional[0])
+	if err != nil {
+		return err
+	}
+	bf, err := open(positional[0], inspected)
+	if err != nil {
+		return err
+	}
+	defer bf.Close()
+
+	before := bf.NextBlockNumber()
+	if err := bf.TruncateBlocks(height); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "truncated %d blocks, next block %d\n", before-bf.NextBlockNumber(), bf.NextBlockNumber())
+	return nil
+}
+
+// open opens the block file for writing with the tables found on disk.
+func open(dir string, inspected *blockfile.Info) (blockfile.BlockFile, error) {
+	if len(inspected.Tables) == 0 {
+		return nil, errors.Errorf("no block file tables in %s", dir)
+	}
+	var tables []string
+	for _, table := range inspected.Tables {
+		tables = append(tables, table.Name)
+	}
+	return blockfile.NewBlockFile(dir, log.NewWithModule("blockfile-tool"), blockfile.WithSchema(tables...))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/axiomesh/axiom-kit/log"
+	"github.com/axiomesh/axiom-kit/storage/blockfile"
+	"github.com/axiomesh/axiom-kit/types"
+)
+
+func prepareBlockFile(t *testing.T, blocks int) string {
+	dir := filepath.Join(t.TempDir(), "blockfile")
+	bf, err := blockfile.NewBlockFile(dir, log.NewWithModule("blockfile-tool_test"))
+	assert.Nil(t, err)
+	for i := 0; i < blocks; i++ {
+		header, err := (&types.BlockHeader{Number: uint64(i), Epoch: 1, TotalGasFee: big.NewInt(0), GasFeeReward: big.NewInt(0)}).Marshal()
+		assert.Nil(t, err)
+		txs, err := types.MarshalTransactions(nil)
+		assert.Nil(t, err)
+		receipts, err := types.MarshalReceipts([]*types.Receipt{{TxHash: types.NewHashByStr("0x1"), GasUsed: uint64(i), EffectiveGasPrice: big.NewInt(1)}})
+		assert.Nil(t, err)
+		assert.Nil(t, bf.AppendBlock(uint64(i), nil, header, nil, receipts, txs))
+	}
+	assert.Nil(t, bf.Close())
+	return dir
+}
+
+func TestInfo(t *testing.T) {
+	dir := prepareBlockFile(t, 3)
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"info", dir}, &out))
+	assert.Contains(t, out.String(), "committed: 3")
+	assert.Contains(t, out.String(), "table "+blockfile.BlockFileHeaderTable)
+	assert.Contains(t, out.String(), "items:     3, 0 pruned")
+	assert.NotContains(t, out.String(), "problem")
+
+	assert.Error(t, run([]string{"info"}, &out))
+	assert.Error(t, run([]string{"unknown", dir}, &out))
+}
+
+func TestDump(t *testing.T) {
+	dir := prepareBlockFile(t, 3)
+	// an append in progress
+	name := filepath.Join(dir, blockfile.BlockFileReceiptsTable+".0000.rdat")
+	data, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = data.Write([]byte("receipts"))
+	assert.Nil(t, err)
+	assert.Nil(t, data.Close())
+
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"verify", dir}, &out))
+	assert.Contains(t, out.String(), "ok: 3 blocks in 4 tables")
+	out.Reset()
+	assert.Nil(t, run([]string{"info", dir}, &out))
+	assert.Contains(t, out.String(), "uncommitted: 8 dangling bytes in data file 0")
+	out.Reset()
+	assert.Nil(t, run([]string{"dump", dir, "2"}, &out))
+	assert.Contains(t, out.String(), `"Number": 2`)
+	assert.Contains(t, out.String(), `"GasUsed": 2`)
+
+	out.Reset()
+	assert.Nil(t, run([]string{"dump", "-raw", dir, "1"}, &out))
+	assert.NotContains(t, out.String(), `"Number"`)
+
+	assert.Error(t, run([]string{"dump", dir, "3"}, &out))
+}
+
+func TestVerify(t *testing.T) {
+	dir := prepareBlockFile(t, 3)
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"verify", dir}, &out))
+	assert.Contains(t, out.String(), "ok: 3 blocks in 4 tables")
+
+	// a corrupted item
+	name := filepath.Join(dir, blockfile.BlockFileReceiptsTable+".0000.rdat")
+	data, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0x01
+	assert.Nil(t, os.WriteFile(name, data, 0644))
+	out.Reset()
+	assert.ErrorIs(t, run([]string{"verify", dir}, &out), errProblems)
+	assert.Contains(t, out.String(), blockfile.BlockFileReceiptsTable+": item 2 fails its checksum")
+
+	// an index pointing beyond the data file
+	assert.Nil(t, os.WriteFile(name, data[:len(data)-1], 0644))
+	out.Reset()
+	assert.ErrorIs(t, run([]string{"verify", dir}, &out), errProblems)
+	assert.Contains(t, out.String(), "item 2 ends at")
+}
+
+func TestRunningNode(t *testing.T) {
+	dir := prepareBlockFile(t, 3)
+	bf, err := blockfile.NewBlockFile(dir, log.NewWithModule("blockfile-tool_test"))
+	assert.Nil(t, err)
+	defer bf.Close()
+
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"dump", dir, "2"}, &out))
+	assert.Contains(t, out.String(), `"Number": 2`)
+	assert.Error(t, run([]string{"truncate", dir, "1"}, &out))
+	assert.EqualValues(t, 3, bf.NextBlockNumber())
+}
+
+func TestTruncate(t *testing.T) {
+	dir := prepareBlockFile(t, 5)
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"truncate", dir, "1"}, &out))
+	assert.Contains(t, out.String(), "truncated 3 blocks, next block 2")
+
+	out.Reset()
+	assert.Nil(t, run([]string{"info", dir}, &out))
+	assert.Contains(t, out.String(), "items:     2, 0 pruned")
+	assert.Error(t, run([]string{"truncate", dir, "x"}, &out))
+}
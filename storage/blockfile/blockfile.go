@@ -1,52 +1,175 @@
 package blockfile
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/prometheus/tsdb/fileutil"
 	"github.com/sirupsen/logrus"
 )
 
 // BlockFile block number start from 0
 //
+// The readers are safe to use concurrently with the appends and truncations and see the
+// blocks below NextBlockNumber only. A block becomes visible once it is appended to every
+// table and stops being visible before any table is truncated, so a read never observes a
+// partially appended or truncated block. An Iterator reads a consistent view per batch,
+// and fails once its next blocks are truncated.
+//
 //go:generate mockgen -destination mock_blockfile/mock_blockfile.go -package mock_blockfile -source blockfile.go -typed
 type BlockFile interface {
 	NextBlockNumber() uint64
 
 	Get(kind string, number uint64) ([]byte, error)
 
+	// GetRange returns the items of the table in [from, to), limited to about maxBytes,
+	// 0 means unlimited. At least the first item is returned.
+	GetRange(kind string, from, to, maxBytes uint64) ([][]byte, error)
+
+	// Iterator returns an iterator over the items of the table in [from, to).
+	Iterator(kind string, from, to uint64) Iterator
+
 	AppendBlock(number uint64, hash, header, extra, receipts, transactions []byte) (err error)
 
 	BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) (err error)
 
+	// AppendRecord appends a block holding an item for every table of the schema.
+	AppendRecord(number uint64, record Record) error
+
+	// BatchAppendRecords appends the blocks starting from number.
+	BatchAppendRecords(number uint64, records []Record) error
+
 	TruncateBlocks(targetBlock uint64) error
 
+	// TruncateTail prunes the blocks below firstKeptBlock, Get returns ErrBlockPruned for them.
+	// Only whole data files are deleted, so some pruned blocks may stay on disk.
+	TruncateTail(firstKeptBlock uint64) error
+
+	// Verify reads every block and returns the numbers of the corrupted items by table.
+	Verify(ctx context.Context) (map[string][]uint64, error)
+
+	// Sync flushes the appended blocks to disk.
+	Sync() error
+
 	Close() error
 }
 
+var (
+	// ErrBlockPruned is returned for the blocks removed by TruncateTail.
+	ErrBlockPruned = errors.New("block pruned")
+
+	// ErrCorrupted is returned for the items failing their checksum.
+	ErrCorrupted = errors.New("block item corrupted")
+)
+
+const (
+	tailFileName     = "TAIL"
+	lockFileName     = "FLOCK" // locked by the writer only
+	readLockFileName = "RLOCK" // shared by the read-only openers, locked by the writer to rewrite files
+
+	defaultMaxFileSize = 2 * 1000 * 1000 * 1000
+)
+
 type blockFile struct {
 	nextBlockNumber uint64 // next block number
+	tail            uint64 // first kept block number
+
+	path string
 
 	tables       map[string]*BlockTable // Data tables for store nextBlockNumber
-	instanceLock fileutil.Releaser      // File-system lock to prevent double opens
+	tableNames   []string               // Sorted names of the tables
+	instanceLock *fileLock              // File-system lock to prevent double opens
+	readersLock  *rwFileLock            // File-system lock protecting the read-only openers from the rewrites
+	commitFile   *os.File               // Commit marker of the appended blocks
+	commitSeq    uint64                 // Seq of the last written commit slot
 
 	logger    logrus.FieldLogger
 	closeOnce sync.Once
 
+	syncPolicy SyncPolicy
+	unsynced   uint64 // number of the blocks appended since the last sync
+	quit       chan struct{}
+	wg         sync.WaitGroup
+
 	appendLock sync.Mutex
+	viewLock   sync.RWMutex // Mutex protecting the readers from the truncations
 }
 
-func NewBlockFile(p string, logger logrus.FieldLogger) (BlockFile, error) {
-	return newBlockFile(p, logger)
+type Option func(*config)
+
+type config struct {
+	schema      []string
+	compression map[string]Compression
+	maxFileSize uint64
+	syncPolicy  SyncPolicy
+}
+
+// SyncPolicy decides when the appended blocks are synced to disk, besides the explicit
+// Sync and Close. The zero policy leaves the flushing to the operating system.
+type SyncPolicy struct {
+	// Blocks syncs on the append reaching Blocks unsynced blocks, 1 syncs every append.
+	Blocks uint64
+
+	// Interval syncs the unsynced blocks in the background every Interval.
+	Interval time.Duration
+}
+
+// SyncAlways syncs every append before it returns.
+var SyncAlways = SyncPolicy{Blocks: 1}
+
+// WithSyncPolicy sets the sync policy of the appended blocks.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(c *config) {
+		c.syncPolicy = policy
+	}
+}
+
+// WithMaxFileSize sets the size at which the data files of the tables are rotated,
+// defaults to 2GB. The size of the existing files is not affected.
+func WithMaxFileSize(size uint64) Option {
+	return func(c *config) {
+		c.maxFileSize = size
+	}
 }
 
-func newBlockFile(p string, logger logrus.FieldLogger) (*blockFile, error) {
+func newConfig(opts []Option) *config {
+	c := &config{maxFileSize: defaultMaxFileSize}
+	for name := range BlockFileSchema {
+		c.schema = append(c.schema, name)
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	sort.Strings(c.schema)
+	if c.maxFileSize == 0 {
+		c.maxFileSize = defaultMaxFileSize
+	}
+	return c
+}
+
+// NewBlockFile opens the block file under p, with the tables of BlockFileSchema by default.
+func NewBlockFile(p string, logger logrus.FieldLogger, opts ...Option) (BlockFile, error) {
+	return newBlockFile(p, logger, opts...)
+}
+
+func newBlockFile(p string, logger logrus.FieldLogger, opts ...Option) (*blockFile, error) {
+	c := newConfig(opts)
+	if len(c.schema) == 0 {
+		return nil, errors.New("empty blockfile schema")
+	}
+	for table, compression := range c.compression {
+		if compression > CompressionZstd {
+			return nil, errors.Wrapf(ErrUnknownCompression, "table %s compression %d", table, compression)
+		}
+	}
 	if info, err := os.Lstat(p); !os.IsNotExist(err) {
 		if info.Mode()&os.ModeSymlink != 0 {
 			logger.WithField("path", p).Error("Symbolic link is not supported")
@@ -57,53 +180,220 @@ func newBlockFile(p string, logger logrus.FieldLogger) (*blockFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	lock, _, err := fileutil.Flock(filepath.Join(p, "FLOCK"))
+	lock, err := lockFile(filepath.Join(p, lockFileName))
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "failed to lock block file, it is opened by another process")
+	}
+	// the read-only openers wait while the tables are upgraded and repaired
+	readersLock, err := openRWFileLock(filepath.Join(p, readLockFileName))
+	if err != nil {
+		_ = lock.release()
+		return nil, errors.Wrap(err, "failed to open read lock file")
+	}
+	if err := readersLock.lock(); err != nil {
+		_ = readersLock.close()
+		_ = lock.release()
+		return nil, errors.Wrap(err, "failed to lock the read-only openers out")
 	}
 	blockfile := &blockFile{
+		path:         p,
 		tables:       make(map[string]*BlockTable),
+		tableNames:   c.schema,
 		instanceLock: lock,
+		readersLock:  readersLock,
 		logger:       logger,
+		syncPolicy:   c.syncPolicy,
+		quit:         make(chan struct{}),
 	}
-	for name := range BlockFileSchema {
-		table, err := newTable(p, name, 2*1000*1000*1000, logger)
+	var added []string
+	for _, name := range c.schema {
+		if !tableExists(p, name) {
+			added = append(added, name)
+		}
+		table, err := newTable(p, name, c.maxFileSize, logger)
 		if err != nil {
 			for _, table := range blockfile.tables {
 				_ = table.Close()
 			}
-			_ = lock.Release()
+			_ = readersLock.close()
+			_ = lock.release()
 			return nil, err
 		}
+		table.compression = c.compression[name]
 		blockfile.tables[name] = table
 	}
+	if err := blockfile.backfill(added); err != nil {
+		for _, table := range blockfile.tables {
+			_ = table.Close()
+		}
+		_ = readersLock.close()
+		_ = lock.release()
+		return nil, err
+	}
+	committed, err := blockfile.openCommit()
+	if err != nil {
+		for _, table := range blockfile.tables {
+			_ = table.Close()
+		}
+		if blockfile.commitFile != nil {
+			_ = blockfile.commitFile.Close()
+		}
+		_ = readersLock.close()
+		_ = lock.release()
+		return nil, err
+	}
+	atomic.StoreUint64(&blockfile.nextBlockNumber, committed)
 	if err := blockfile.repair(); err != nil {
 		for _, table := range blockfile.tables {
 			_ = table.Close()
 		}
-		_ = lock.Release()
+		_ = blockfile.commitFile.Close()
+		_ = readersLock.close()
+		_ = lock.release()
+		return nil, err
+	}
+	if err := blockfile.repairTail(); err != nil {
+		for _, table := range blockfile.tables {
+			_ = table.Close()
+		}
+		_ = blockfile.commitFile.Close()
+		_ = readersLock.close()
+		_ = lock.release()
+		return nil, err
+	}
+	if err := readersLock.unlock(); err != nil {
+		for _, table := range blockfile.tables {
+			_ = table.Close()
+		}
+		_ = blockfile.commitFile.Close()
+		_ = readersLock.close()
+		_ = lock.release()
 		return nil, err
 	}
+	if c.syncPolicy.Interval > 0 {
+		blockfile.wg.Add(1)
+		go blockfile.syncLoop(c.syncPolicy.Interval)
+	}
 
 	return blockfile, nil
 }
 
+// backfill fills the tables added to an existing data dir with empty items,
+// so that they are as long as the existing tables.
+func (bf *blockFile) backfill(added []string) error {
+	if len(added) == 0 || len(added) == len(bf.tables) {
+		return nil
+	}
+	var (
+		from uint64
+		to   = uint64(math.MaxUint64)
+	)
+	for name, table := range bf.tables {
+		if containsTable(added, name) {
+			continue
+		}
+		if offset := uint64(table.itemOffset); offset > from {
+			from = offset
+		}
+		if items := atomic.LoadUint64(&table.items); items < to {
+			to = items
+		}
+	}
+	if from > to {
+		from = to
+	}
+	for _, name := range added {
+		bf.logger.WithFields(logrus.Fields{
+			"table": name,
+			"from":  from,
+			"to":    to,
+		}).Info("Backfill table added to blockfile")
+		if err := bf.tables[name].backfill(from, to); err != nil {
+			return errors.Wrapf(err, "failed to backfill table %s", name)
+		}
+	}
+	return nil
+}
+
+// repairTail loads the persisted tail and finishes an interrupted TruncateTail.
+func (bf *blockFile) repairTail() error {
+	data, err := os.ReadFile(filepath.Join(bf.path, tailFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != 8 {
+		return errors.Errorf("corrupted tail file of size %d", len(data))
+	}
+	tail := binary.BigEndian.Uint64(data)
+	if next := atomic.LoadUint64(&bf.nextBlockNumber); tail > next {
+		// the pruned blocks were lost in a crash
+		tail = next
+	}
+	for _, table := range bf.tables {
+		if err := table.truncateTail(tail); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&bf.tail, tail)
+	return nil
+}
+
 func (bf *blockFile) NextBlockNumber() uint64 {
 	return atomic.LoadUint64(&bf.nextBlockNumber)
 }
 
 func (bf *blockFile) Get(kind string, number uint64) ([]byte, error) {
+	bf.viewLock.RLock()
+	defer bf.viewLock.RUnlock()
+
 	if table := bf.tables[kind]; table != nil {
+		if number < atomic.LoadUint64(&bf.tail) {
+			return nil, ErrBlockPruned
+		}
+		if number >= atomic.LoadUint64(&bf.nextBlockNumber) {
+			return nil, errors.New("out of bounds")
+		}
 		return table.Retrieve(number)
 	}
 	return nil, errors.New("unknown table")
 }
 
+func (bf *blockFile) GetRange(kind string, from, to, maxBytes uint64) ([][]byte, error) {
+	bf.viewLock.RLock()
+	defer bf.viewLock.RUnlock()
+
+	table := bf.tables[kind]
+	if table == nil {
+		return nil, errors.New("unknown table")
+	}
+	if from < atomic.LoadUint64(&bf.tail) {
+		return nil, ErrBlockPruned
+	}
+	if to <= from {
+		return nil, errors.Errorf("invalid range [%d, %d)", from, to)
+	}
+	next := atomic.LoadUint64(&bf.nextBlockNumber)
+	if from >= next {
+		return nil, errors.New("out of bounds")
+	}
+	if to > next {
+		to = next
+	}
+	return table.RetrieveRange(from, to-from, maxBytes)
+}
+
+func (bf *blockFile) Iterator(kind string, from, to uint64) Iterator {
+	return newRangeIterator(bf, kind, from, to)
+}
+
 func (bf *blockFile) AppendBlock(number uint64, hash, header, extra, receipts, transactions []byte) (err error) {
 	bf.appendLock.Lock()
 	defer bf.appendLock.Unlock()
 
-	err = bf.doBatchAppendBlock(number, [][]byte{header}, [][]byte{extra}, [][]byte{receipts}, [][]byte{transactions})
+	err = bf.doBatchAppendRecords(number, []Record{bf.blockRecord(hash, header, extra, receipts, transactions)})
 	if err != nil {
 		bf.logger.WithFields(logrus.Fields{
 			"number": bf.nextBlockNumber,
@@ -117,11 +407,14 @@ func (bf *blockFile) AppendBlock(number uint64, hash, header, extra, receipts, t
 func (bf *blockFile) BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) (err error) {
 	bf.appendLock.Lock()
 	defer bf.appendLock.Unlock()
-	err = bf.doBatchAppendBlock(number, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions)
+
+	records, err := bf.blockRecords(listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions)
+	if err == nil {
+		err = bf.doBatchAppendRecords(number, records)
+	}
 	if err != nil {
 		bf.logger.WithFields(logrus.Fields{
 			"number":              bf.nextBlockNumber,
-			"first_hash":          listOfHash[0],
 			"batch_append_number": len(listOfHash),
 			"err":                 err,
 		}).Error("Failed to batch append block")
@@ -129,17 +422,113 @@ func (bf *blockFile) BatchAppendBlock(number uint64, listOfHash, listOfHeader, l
 	return err
 }
 
-func (bf *blockFile) doBatchAppendBlock(number uint64, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) error {
+// blockRecord returns the record of a block, the hash is kept only if the schema has the hash table.
+func (bf *blockFile) blockRecord(hash, header, extra, receipts, transactions []byte) Record {
+	record := Record{
+		BlockFileHeaderTable:   header,
+		BlockFileTXsTable:      transactions,
+		BlockFileExtraTable:    extra,
+		BlockFileReceiptsTable: receipts,
+	}
+	if _, ok := bf.tables[BlockFileHashTable]; ok {
+		record[BlockFileHashTable] = hash
+	}
+	return record
+}
+
+func (bf *blockFile) blockRecords(listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) ([]Record, error) {
+	batchNum := len(listOfHeader)
+	if !(batchNum == len(listOfHash) && batchNum == len(listOfExtra) && batchNum == len(listOfReceipts) && batchNum == len(listOfTransactions)) {
+		return nil, errors.New("doBatch append block data param's length not match")
+	}
+	records := make([]Record, batchNum)
+	for i := range records {
+		records[i] = bf.blockRecord(listOfHash[i], listOfHeader[i], listOfExtra[i], listOfReceipts[i], listOfTransactions[i])
+	}
+	return records, nil
+}
+
+func (bf *blockFile) AppendRecord(number uint64, record Record) error {
+	return bf.BatchAppendRecords(number, []Record{record})
+}
+
+func (bf *blockFile) BatchAppendRecords(number uint64, records []Record) error {
+	bf.appendLock.Lock()
+	defer bf.appendLock.Unlock()
+
+	err := bf.doBatchAppendRecords(number, records)
+	if err != nil {
+		bf.logger.WithFields(logrus.Fields{
+			"number":              bf.nextBlockNumber,
+			"batch_append_number": len(records),
+			"err":                 err,
+		}).Error("Failed to batch append records")
+	}
+	return err
+}
+
+// Sync flushes the appended blocks to disk.
+func (bf *blockFile) Sync() error {
+	bf.appendLock.Lock()
+	defer bf.appendLock.Unlock()
+	return bf.syncTables()
+}
+
+// syncTables syncs every table and then the commit marker, so that the synced marker never
+// counts unsynced blocks. It must be called with the append lock held.
+func (bf *blockFile) syncTables() error {
+	if err := bf.syncData(); err != nil {
+		return err
+	}
+	if err := bf.commitFile.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync commit marker")
+	}
+	bf.unsynced = 0
+	return nil
+}
+
+func (bf *blockFile) syncData() error {
+	for _, name := range bf.tableNames {
+		if err := bf.tables[name].Sync(); err != nil {
+			return errors.Wrapf(err, "failed to sync table %s", name)
+		}
+	}
+	return nil
+}
+
+func (bf *blockFile) syncLoop(interval time.Duration) {
+	defer bf.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bf.appendLock.Lock()
+			if bf.unsynced > 0 {
+				if err := bf.syncTables(); err != nil {
+					bf.logger.WithField("err", err).Error("Failed to sync blockfile")
+				}
+			}
+			bf.appendLock.Unlock()
+		case <-bf.quit:
+			return
+		}
+	}
+}
+
+func (bf *blockFile) doBatchAppendRecords(number uint64, records []Record) error {
 	if atomic.LoadUint64(&bf.nextBlockNumber) != number {
 		return errors.New("the append operation is out-order")
 	}
 
-	batchNum := len(listOfHeader)
+	batchNum := len(records)
 	if batchNum == 0 {
 		return errors.New("empty doBatch append block data")
 	}
-	if !(batchNum == len(listOfExtra) && batchNum == len(listOfReceipts) && batchNum == len(listOfTransactions)) {
-		return errors.New("doBatch append block data param's length not match")
+	for _, record := range records {
+		if err := validateRecord(bf.tableNames, record); err != nil {
+			return err
+		}
 	}
 
 	var err error
@@ -155,38 +544,131 @@ func (bf *blockFile) doBatchAppendBlock(number uint64, listOfHeader, listOfExtra
 			}).Info("Append block failed")
 		}
 	}()
-	if err = bf.tables[BlockFileHeaderTable].BatchAppend(bf.nextBlockNumber, listOfHeader); err != nil {
-		return errors.Wrap(err, "failed to append block header")
-	}
-	if err = bf.tables[BlockFileTXsTable].BatchAppend(bf.nextBlockNumber, listOfTransactions); err != nil {
-		return errors.Wrap(err, "failed to append block transactions")
+	items := make([][]byte, batchNum)
+	for _, name := range bf.tableNames {
+		for i, record := range records {
+			items[i] = record[name]
+		}
+		if err = bf.tables[name].BatchAppend(bf.nextBlockNumber, items); err != nil {
+			return errors.Wrapf(err, "failed to append block %s", name)
+		}
 	}
-	if err = bf.tables[BlockFileExtraTable].BatchAppend(bf.nextBlockNumber, listOfExtra); err != nil {
-		return errors.Wrap(err, "failed to append block extra")
+
+	// the blocks exist once the commit marker counts them, the blocks written
+	// to the tables but not committed are dropped by repair
+	needSync := bf.syncPolicy.Blocks > 0 && bf.unsynced+uint64(batchNum) >= bf.syncPolicy.Blocks
+	if needSync {
+		if err = bf.syncData(); err != nil {
+			return err
+		}
 	}
-	if err = bf.tables[BlockFileReceiptsTable].BatchAppend(bf.nextBlockNumber, listOfReceipts); err != nil {
-		return errors.Wrap(err, "failed to append block receipts")
+	if err = bf.commit(number + uint64(batchNum)); err != nil {
+		return errors.Wrap(err, "failed to commit blocks")
 	}
 	atomic.AddUint64(&bf.nextBlockNumber, uint64(batchNum)) // Only modify atomically
+
+	bf.unsynced += uint64(batchNum)
+	if needSync {
+		// the blocks stay appended, but the caller must not consider them durable
+		if serr := bf.syncTables(); serr != nil {
+			return errors.Wrapf(serr, "failed to sync blocks [%d, %d)", number, number+uint64(batchNum))
+		}
+	}
 	return nil
 }
 
 func (bf *blockFile) TruncateBlocks(targetBlock uint64) error {
+	bf.appendLock.Lock()
+	defer bf.appendLock.Unlock()
+
 	if targetBlock >= atomic.LoadUint64(&bf.nextBlockNumber) {
 		return nil
 	}
+	if tail := atomic.LoadUint64(&bf.tail); targetBlock+1 < tail {
+		return errors.Wrapf(ErrBlockPruned, "truncate blocks to %d below tail %d", targetBlock, tail)
+	}
+	// the read-only openers must not read the items being truncated
+	if err := bf.readersLock.lock(); err != nil {
+		return errors.Wrap(err, "failed to lock the read-only openers out")
+	}
+	defer bf.readersLock.unlock()
+
+	// uncommit the blocks first, so that a crash in the middle drops them on open
+	if err := bf.commit(targetBlock + 1); err != nil {
+		return err
+	}
+
+	// hide the blocks from the readers before truncating the tables
+	bf.viewLock.Lock()
+	defer bf.viewLock.Unlock()
+	atomic.StoreUint64(&bf.nextBlockNumber, targetBlock+1)
 	for _, table := range bf.tables {
 		if err := table.truncate(targetBlock + 1); err != nil {
 			return err
 		}
 	}
-	atomic.StoreUint64(&bf.nextBlockNumber, targetBlock+1)
 	return nil
 }
 
-// repair truncates all data tables to the same length.
+func (bf *blockFile) TruncateTail(firstKeptBlock uint64) error {
+	bf.appendLock.Lock()
+	defer bf.appendLock.Unlock()
+
+	if firstKeptBlock <= atomic.LoadUint64(&bf.tail) {
+		return nil
+	}
+	if next := atomic.LoadUint64(&bf.nextBlockNumber); firstKeptBlock > next {
+		return errors.Errorf("truncate tail to %d beyond next block %d", firstKeptBlock, next)
+	}
+
+	// persist the tail first, an interrupted truncation is finished on the next open
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], firstKeptBlock)
+	tailPath := filepath.Join(bf.path, tailFileName)
+	if err := writeFileSync(tailPath+".tmp", data[:]); err != nil {
+		return err
+	}
+	if err := os.Rename(tailPath+".tmp", tailPath); err != nil {
+		return err
+	}
+
+	// the read-only openers must not read the files being removed
+	if err := bf.readersLock.lock(); err != nil {
+		return errors.Wrap(err, "failed to lock the read-only openers out")
+	}
+	defer bf.readersLock.unlock()
+
+	bf.viewLock.Lock()
+	defer bf.viewLock.Unlock()
+	atomic.StoreUint64(&bf.tail, firstKeptBlock)
+
+	for _, table := range bf.tables {
+		if err := table.truncateTail(firstKeptBlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bf *blockFile) Verify(ctx context.Context) (map[string][]uint64, error) {
+	corrupted := make(map[string][]uint64)
+	for _, name := range bf.tableNames {
+		items, err := bf.tables[name].verify(ctx)
+		if len(items) > 0 {
+			corrupted[name] = items
+		}
+		if err != nil {
+			return corrupted, errors.Wrapf(err, "failed to verify table %s", name)
+		}
+	}
+	return corrupted, nil
+}
+
+// repair truncates all data tables to the committed blocks, or to the shortest table
+// if some committed blocks were lost in a crash.
 func (bf *blockFile) repair() error {
-	minNumber := uint64(math.MaxUint64)
+	committed := atomic.LoadUint64(&bf.nextBlockNumber)
+	minNumber := committed
 	for _, table := range bf.tables {
 		items := atomic.LoadUint64(&table.items)
 		if minNumber > items {
@@ -198,6 +680,14 @@ func (bf *blockFile) repair() error {
 			return err
 		}
 	}
+	if minNumber != committed {
+		if err := bf.commit(minNumber); err != nil {
+			return err
+		}
+		if err := bf.commitFile.Sync(); err != nil {
+			return err
+		}
+	}
 	atomic.StoreUint64(&bf.nextBlockNumber, minNumber)
 	return nil
 }
@@ -205,12 +695,26 @@ func (bf *blockFile) repair() error {
 func (bf *blockFile) Close() error {
 	var errs []error
 	bf.closeOnce.Do(func() {
+		close(bf.quit)
+		bf.wg.Wait()
+
+		bf.appendLock.Lock()
+		defer bf.appendLock.Unlock()
+		if err := bf.syncTables(); err != nil {
+			errs = append(errs, err)
+		}
 		for _, table := range bf.tables {
 			if err := table.Close(); err != nil {
 				errs = append(errs, err)
 			}
 		}
-		if err := bf.instanceLock.Release(); err != nil {
+		if err := bf.commitFile.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := bf.readersLock.close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := bf.instanceLock.release(); err != nil {
 			errs = append(errs, err)
 		}
 	})
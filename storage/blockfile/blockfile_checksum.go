@@ -0,0 +1,224 @@
+package blockfile
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The checksum sidecar of a table holds the first checksummed item(8) and then
+// the crc32c(4) of the stored bytes of every item from the first checksummed one.
+// Items written before the sidecar existed are not checksummed.
+const (
+	checksumHeaderSize = 8
+	checksumSize       = 4
+
+	verifyCheckInterval = 1024
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (b *BlockTable) checksumPath() string {
+	return filepath.Join(b.path, fmt.Sprintf("%s.rcrc", b.name))
+}
+
+// repairChecksums opens the checksum sidecar and syncs it with the index,
+// the missing checksums of the indexed items are computed from the data files.
+func (b *BlockTable) repairChecksums() error {
+	if b.checksums == nil {
+		f, err := openBlockFileForAppend(b.checksumPath())
+		if err != nil {
+			return err
+		}
+		b.checksums = f
+	}
+	stat, err := b.checksums.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < checksumHeaderSize {
+		return b.resetChecksums(b.items)
+	}
+	header := make([]byte, checksumHeaderSize)
+	if _, err := b.checksums.ReadAt(header, 0); err != nil {
+		return err
+	}
+	first := binary.BigEndian.Uint64(header)
+	if first > b.items {
+		return b.resetChecksums(b.items)
+	}
+	b.checksumFirst = first
+	if first < uint64(b.itemOffset) {
+		// interrupted tail truncation
+		if err := b.truncateChecksumsTail(uint64(b.itemOffset)); err != nil {
+			return err
+		}
+		return b.repairChecksums()
+	}
+
+	count := uint64(stat.Size()-checksumHeaderSize) / checksumSize
+	expected := b.items - first
+	if count > expected || (stat.Size()-checksumHeaderSize)%checksumSize != 0 {
+		if count > expected {
+			count = expected
+		}
+		if err := truncateBlockFile(b.checksums, checksumHeaderSize+int64(count)*checksumSize); err != nil {
+			return err
+		}
+	}
+	if count < expected {
+		b.logger.WithFields(logrus.Fields{
+			"table":   b.name,
+			"indexed": expected,
+			"stored":  count,
+		}).Warn("Recompute missing checksums")
+		for item := first + count; item < b.items; item++ {
+			blob, _, err := b.readItem(item)
+			if err != nil {
+				return err
+			}
+			if _, err := b.checksums.Write(checksumBytes(blob)); err != nil {
+				return err
+			}
+		}
+	}
+	return b.checksums.Sync()
+}
+
+// resetChecksums empties the checksum sidecar, so that the items from first are checksummed.
+func (b *BlockTable) resetChecksums(first uint64) error {
+	if err := truncateBlockFile(b.checksums, 0); err != nil {
+		return err
+	}
+	header := make([]byte, checksumHeaderSize)
+	binary.BigEndian.PutUint64(header, first)
+	if _, err := b.checksums.Write(header); err != nil {
+		return err
+	}
+	b.checksumFirst = first
+	return b.checksums.Sync()
+}
+
+// truncateChecksums drops the checksums of the items from items on.
+func (b *BlockTable) truncateChecksums(items uint64) error {
+	if items < b.checksumFirst {
+		return b.resetChecksums(items)
+	}
+	return truncateBlockFile(b.checksums, checksumHeaderSize+int64(items-b.checksumFirst)*checksumSize)
+}
+
+// truncateChecksumsTail drops the checksums of the items below tail.
+func (b *BlockTable) truncateChecksumsTail(tail uint64) error {
+	if tail <= b.checksumFirst {
+		return nil
+	}
+	stat, err := b.checksums.Stat()
+	if err != nil {
+		return err
+	}
+	keptOffset := checksumHeaderSize + int64(tail-b.checksumFirst)*checksumSize
+	data := make([]byte, checksumHeaderSize, checksumHeaderSize+stat.Size()-keptOffset)
+	binary.BigEndian.PutUint64(data, tail)
+	if keptOffset < stat.Size() {
+		kept := make([]byte, stat.Size()-keptOffset)
+		if _, err := b.checksums.ReadAt(kept, keptOffset); err != nil {
+			return err
+		}
+		data = append(data, kept...)
+	}
+
+	path := b.checksumPath()
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := b.checksums.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if b.checksums, err = openBlockFileForAppend(path); err != nil {
+		return err
+	}
+	b.checksumFirst = tail
+	return nil
+}
+
+// verifyChecksum checks the stored bytes of the item against its checksum, if any.
+func (b *BlockTable) verifyChecksum(item uint64, blob []byte) error {
+	if item < b.checksumFirst {
+		return nil
+	}
+	expected := make([]byte, checksumSize)
+	if _, err := b.checksums.ReadAt(expected, checksumHeaderSize+int64(item-b.checksumFirst)*checksumSize); err != nil {
+		return errors.Wrapf(ErrCorrupted, "table %s item %d: missing checksum: %v", b.name, item, err)
+	}
+	if binary.BigEndian.Uint32(expected) != crc32.Checksum(blob, checksumTable) {
+		return errors.Wrapf(ErrCorrupted, "table %s item %d: checksum mismatch", b.name, item)
+	}
+	return nil
+}
+
+// verifyChecksums checks the stored bytes of the items from the given one against their checksums.
+func (b *BlockTable) verifyChecksums(from uint64, blobs [][]byte) error {
+	skipped := uint64(0)
+	if from < b.checksumFirst {
+		skipped = b.checksumFirst - from
+		if skipped >= uint64(len(blobs)) {
+			return nil
+		}
+	}
+	first := from + skipped
+	expected := make([]byte, (uint64(len(blobs))-skipped)*checksumSize)
+	if _, err := b.checksums.ReadAt(expected, checksumHeaderSize+int64(first-b.checksumFirst)*checksumSize); err != nil {
+		return errors.Wrapf(ErrCorrupted, "table %s items from %d: missing checksums: %v", b.name, first, err)
+	}
+	for i, blob := range blobs[skipped:] {
+		if binary.BigEndian.Uint32(expected[i*checksumSize:]) != crc32.Checksum(blob, checksumTable) {
+			return errors.Wrapf(ErrCorrupted, "table %s item %d: checksum mismatch", b.name, first+uint64(i))
+		}
+	}
+	return nil
+}
+
+func checksumBytes(blobs ...[]byte) []byte {
+	b := make([]byte, 0, len(blobs)*checksumSize)
+	for _, blob := range blobs {
+		b = binary.BigEndian.AppendUint32(b, crc32.Checksum(blob, checksumTable))
+	}
+	return b
+}
+
+// verify reads every item of the table and returns the corrupted ones.
+func (b *BlockTable) verify(ctx context.Context) ([]uint64, error) {
+	var corrupted []uint64
+	b.lock.RLock()
+	from := uint64(b.itemOffset)
+	b.lock.RUnlock()
+	for item := from; item < atomic.LoadUint64(&b.items); item++ {
+		if (item-from)%verifyCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return corrupted, err
+			}
+		}
+		if _, err := b.Retrieve(item); err != nil {
+			if errors.Is(err, ErrCorrupted) {
+				corrupted = append(corrupted, item)
+				continue
+			}
+			if errors.Is(err, ErrBlockPruned) {
+				// pruned by a concurrent TruncateTail
+				continue
+			}
+			return corrupted, err
+		}
+	}
+	return corrupted, nil
+}
@@ -0,0 +1,64 @@
+package blockfile
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// The commit marker holds the number of the blocks fully written to every table. It is
+// written after the tables on every append, so the blocks above it are torn and dropped
+// on open. The marker alternates between two slots of seq(8) | number(8) | crc32c(4),
+// a torn write only damages the slot being written and the other one is used instead.
+const (
+	commitFileName = "COMMIT"
+	commitSlotSize = 20
+)
+
+// openCommit opens the commit marker and returns the committed block number, which is
+// math.MaxUint64 if no valid slot exists, like in data dirs written before the marker.
+func (bf *blockFile) openCommit() (uint64, error) {
+	f, err := os.OpenFile(filepath.Join(bf.path, commitFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	bf.commitFile = f
+
+	data := make([]byte, 2*commitSlotSize)
+	n, err := f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	committed := uint64(math.MaxUint64)
+	for slot := 0; slot+commitSlotSize <= n; slot += commitSlotSize {
+		seq, number, ok := decodeCommitSlot(data[slot : slot+commitSlotSize])
+		if ok && (committed == math.MaxUint64 || seq > bf.commitSeq) {
+			bf.commitSeq, committed = seq, number
+		}
+	}
+	return committed, nil
+}
+
+// commit writes number into the slot of the next seq, the marker is synced with the tables.
+func (bf *blockFile) commit(number uint64) error {
+	seq := bf.commitSeq + 1
+	slot := make([]byte, commitSlotSize)
+	binary.BigEndian.PutUint64(slot[0:8], seq)
+	binary.BigEndian.PutUint64(slot[8:16], number)
+	binary.BigEndian.PutUint32(slot[16:20], crc32.Checksum(slot[:16], checksumTable))
+	if _, err := bf.commitFile.WriteAt(slot, int64(seq%2)*commitSlotSize); err != nil {
+		return err
+	}
+	bf.commitSeq = seq
+	return nil
+}
+
+func decodeCommitSlot(slot []byte) (uint64, uint64, bool) {
+	if crc32.Checksum(slot[:16], checksumTable) != binary.BigEndian.Uint32(slot[16:20]) {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(slot[0:8]), binary.BigEndian.Uint64(slot[8:16]), true
+}
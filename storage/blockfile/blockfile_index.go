@@ -0,0 +1,158 @@
+package blockfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The index file of a table starts with magic(4) | version(4), followed by the index entries.
+// The first entry holds the tail: the number of the earliest data file and the number of the
+// deleted items, every other entry holds the end of an item in its data file.
+//
+// Version 1 indexes, stored without header in the .ridx file, are upgraded on open.
+const (
+	indexMagic         = "BFIX"
+	indexVersion       = 2
+	indexHeaderSize    = 8
+	indexEntrySize     = 13
+	indexFileExtension = "idx"
+
+	// v1 entry: filenum(2) | offset(4), v1 tables are never compressed
+	indexEntrySizeV1     = 6
+	indexFileExtensionV1 = "ridx"
+)
+
+type indexEntry struct {
+	filenum     uint32      // stored as uint32 ( 4 bytes)
+	compression Compression // stored as uint8 ( 1 byte)
+	offset      uint64      // stored as uint64 ( 8 bytes)
+}
+
+// unmarshallBinary deserializes binary b into the rawIndex entry.
+func (i *indexEntry) unmarshalBinary(b []byte) error {
+	i.filenum = binary.BigEndian.Uint32(b[:4])
+	i.compression = Compression(b[4])
+	i.offset = binary.BigEndian.Uint64(b[5:13])
+	return nil
+}
+
+// marshallBinary serializes the rawIndex entry into binary.
+func (i *indexEntry) marshallBinary() []byte {
+	b := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint32(b[:4], i.filenum)
+	b[4] = byte(i.compression)
+	binary.BigEndian.PutUint64(b[5:13], i.offset)
+	return b
+}
+
+func (i *indexEntry) unmarshalBinaryV1(b []byte) {
+	i.filenum = uint32(binary.BigEndian.Uint16(b[:2]))
+	i.compression = CompressionNone
+	i.offset = uint64(binary.BigEndian.Uint32(b[2:6]))
+}
+
+// entryOffset returns the offset of the index entry at the given position in the index file.
+func entryOffset(position uint64) int64 {
+	return indexHeaderSize + int64(position)*indexEntrySize
+}
+
+func indexHeader() []byte {
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic)
+	binary.BigEndian.PutUint32(header[4:], indexVersion)
+	return header
+}
+
+func checkIndexHeader(header []byte) error {
+	if string(header[:4]) != indexMagic {
+		return errors.New("invalid index magic")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != indexVersion {
+		return errors.Errorf("unsupported index version %d", version)
+	}
+	return nil
+}
+
+func indexPath(path, name string) string {
+	return filepath.Join(path, fmt.Sprintf("%s.%s", name, indexFileExtension))
+}
+
+// tableExists reports whether the index of the table exists in any version.
+func tableExists(path, name string) bool {
+	for _, ext := range []string{indexFileExtension, indexFileExtensionV1} {
+		if _, err := os.Stat(filepath.Join(path, fmt.Sprintf("%s.%s", name, ext))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeIndex converts the v1 index of the table, if any, to the current version.
+// The v1 index is removed once the upgraded one is in place.
+func upgradeIndex(path, name string, logger logrus.FieldLogger) error {
+	v1Path := filepath.Join(path, fmt.Sprintf("%s.%s", name, indexFileExtensionV1))
+	v1, err := os.Open(v1Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer v1.Close()
+
+	v2Path := indexPath(path, name)
+	if _, err := os.Stat(v2Path); err == nil {
+		// upgraded before the v1 index was removed
+		return os.Remove(v1Path)
+	}
+
+	tmp, err := os.OpenFile(v2Path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var (
+		r       = bufio.NewReader(v1)
+		w       = bufio.NewWriter(tmp)
+		buffer  = make([]byte, indexEntrySizeV1)
+		entries uint64
+	)
+	_, err = w.Write(indexHeader())
+	for err == nil {
+		if _, err = io.ReadFull(r, buffer); err != nil {
+			break
+		}
+		var entry indexEntry
+		entry.unmarshalBinaryV1(buffer)
+		_, err = w.Write(entry.marshallBinary())
+		entries++
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// a torn v1 entry is dropped like on repair
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to upgrade index of table %s", name)
+	}
+	if err := os.Rename(v2Path+".tmp", v2Path); err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"table":   name,
+		"entries": entries,
+		"version": indexVersion,
+	}).Info("Upgraded blockfile index")
+	return os.Remove(v1Path)
+}
@@ -0,0 +1,440 @@
+package blockfile
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrReadOnly is returned by the writes to a block file opened by OpenReadOnly.
+var ErrReadOnly = errors.New("block file is read-only")
+
+// readOnlyLoadAttempts bounds the loads of a table racing with the tail truncations of the writer.
+const readOnlyLoadAttempts = 3
+
+type readOnlyBlockFile struct {
+	path string
+
+	tables      map[string]*BlockTable
+	tableNames  []string
+	readersLock *rwFileLock
+	commitFile  *os.File
+
+	logger    logrus.FieldLogger
+	closeOnce sync.Once
+}
+
+// OpenReadOnly opens the block file under p for reading, with the tables found on disk, while
+// another process may append to it, like the indexers and the backup tools of a running node.
+// It shares the RLOCK file with the other readers while it reads, and the writer locks it
+// exclusively while it repairs, truncates or removes files, so the reads wait for those and
+// the writer opens, and restarts, while the readers are open. Nothing is repaired nor written,
+// the RLOCK file is created if the block file was never opened by such a writer.
+//
+// The blocks below the commit marker written by the writer are visible, which is re-read on
+// every read, so the appended blocks become visible and the truncated ones stop being visible
+// like in the writer. The reads racing with a truncation of the writer may fail. The pruned
+// blocks still stored in the kept data files stay readable.
+func OpenReadOnly(p string, logger logrus.FieldLogger) (BlockFile, error) {
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	lock, err := openRWFileLock(filepath.Join(p, readLockFileName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open read lock file")
+	}
+	bf := &readOnlyBlockFile{
+		path:        p,
+		tables:      make(map[string]*BlockTable),
+		readersLock: lock,
+		logger:      logger,
+	}
+	if err := bf.open(); err != nil {
+		for _, table := range bf.tables {
+			_ = table.Close()
+		}
+		if bf.commitFile != nil {
+			_ = bf.commitFile.Close()
+		}
+		_ = lock.close()
+		return nil, err
+	}
+	return bf, nil
+}
+
+func (bf *readOnlyBlockFile) open() error {
+	if err := bf.readersLock.rlock(); err != nil {
+		return errors.Wrap(err, "failed to lock block file")
+	}
+	defer bf.readersLock.runlock()
+
+	entries, err := os.ReadDir(bf.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, "."+indexFileExtension):
+			bf.tableNames = append(bf.tableNames, strings.TrimSuffix(name, "."+indexFileExtension))
+		case strings.HasSuffix(name, "."+indexFileExtensionV1):
+			table := strings.TrimSuffix(name, "."+indexFileExtensionV1)
+			if !tableExists(bf.path, table) {
+				return errors.Errorf("table %s has a v1 index, open the block file for writing to upgrade it", table)
+			}
+		}
+	}
+	if len(bf.tableNames) == 0 {
+		return errors.Errorf("no block file tables in %s", bf.path)
+	}
+	sort.Strings(bf.tableNames)
+	for _, name := range bf.tableNames {
+		table, err := openReadOnlyTable(bf.path, name, bf.logger)
+		if err != nil {
+			return err
+		}
+		bf.tables[name] = table
+	}
+	bf.commitFile, err = os.Open(filepath.Join(bf.path, commitFileName))
+	return err
+}
+
+// committed reads the commit marker, it returns math.MaxUint64 if no slot is valid.
+func (bf *readOnlyBlockFile) committed() (uint64, error) {
+	data := make([]byte, 2*commitSlotSize)
+	n, err := bf.commitFile.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	var (
+		committed = uint64(math.MaxUint64)
+		last      uint64
+	)
+	for slot := 0; slot+commitSlotSize <= n; slot += commitSlotSize {
+		seq, number, ok := decodeCommitSlot(data[slot : slot+commitSlotSize])
+		if ok && (committed == math.MaxUint64 || seq > last) {
+			last, committed = seq, number
+		}
+	}
+	return committed, nil
+}
+
+func (bf *readOnlyBlockFile) NextBlockNumber() uint64 {
+	committed, err := bf.committed()
+	if err != nil {
+		bf.logger.WithField("err", err).Warn("Failed to read commit marker")
+		return 0
+	}
+	if committed != math.MaxUint64 {
+		return committed
+	}
+	// the block files written before the marker have no append in progress
+	next := uint64(math.MaxUint64)
+	for _, table := range bf.tables {
+		if err := table.refresh(); err != nil {
+			bf.logger.WithFields(logrus.Fields{
+				"table": table.name,
+				"err":   err,
+			}).Warn("Failed to refresh table")
+			return 0
+		}
+		if items := atomic.LoadUint64(&table.items); items < next {
+			next = items
+		}
+	}
+	return next
+}
+
+func (bf *readOnlyBlockFile) Get(kind string, number uint64) ([]byte, error) {
+	table := bf.tables[kind]
+	if table == nil {
+		return nil, errors.New("unknown table")
+	}
+	var item []byte
+	err := bf.read(table, number, number+1, func() (err error) {
+		item, err = table.Retrieve(number)
+		return err
+	})
+	return item, err
+}
+
+func (bf *readOnlyBlockFile) GetRange(kind string, from, to, maxBytes uint64) ([][]byte, error) {
+	table := bf.tables[kind]
+	if table == nil {
+		return nil, errors.New("unknown table")
+	}
+	if to <= from {
+		return nil, errors.Errorf("invalid range [%d, %d)", from, to)
+	}
+	if next := bf.NextBlockNumber(); to > next {
+		to = next
+	}
+	var items [][]byte
+	err := bf.read(table, from, to, func() (err error) {
+		items, err = table.RetrieveRange(from, to-from, maxBytes)
+		return err
+	})
+	return items, err
+}
+
+// read reads the items in [from, to) from the table by calling read. The appended items
+// are loaded first, and the table is reloaded and read again if the read fails, as its
+// files may have been truncated or replaced by the writer since they were opened.
+func (bf *readOnlyBlockFile) read(table *BlockTable, from, to uint64, read func() error) error {
+	if err := bf.readersLock.rlock(); err != nil {
+		return errors.Wrap(err, "failed to lock block file")
+	}
+	defer bf.readersLock.runlock()
+
+	if from >= to || from >= bf.NextBlockNumber() {
+		return errors.New("out of bounds")
+	}
+	if to > atomic.LoadUint64(&table.items) {
+		if err := table.refresh(); err != nil {
+			return err
+		}
+	}
+	err := read()
+	if err == nil || errors.Is(err, ErrBlockPruned) {
+		return err
+	}
+	if from >= bf.NextBlockNumber() {
+		// truncated since the bounds were checked
+		return errors.New("out of bounds")
+	}
+	if err := table.reload(); err != nil {
+		return err
+	}
+	return read()
+}
+
+func (bf *readOnlyBlockFile) Iterator(kind string, from, to uint64) Iterator {
+	return newRangeIterator(bf, kind, from, to)
+}
+
+func (bf *readOnlyBlockFile) AppendBlock(number uint64, hash, header, extra, receipts, transactions []byte) error {
+	return ErrReadOnly
+}
+
+func (bf *readOnlyBlockFile) BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) error {
+	return ErrReadOnly
+}
+
+func (bf *readOnlyBlockFile) AppendRecord(number uint64, record Record) error {
+	return ErrReadOnly
+}
+
+func (bf *readOnlyBlockFile) BatchAppendRecords(number uint64, records []Record) error {
+	return ErrReadOnly
+}
+
+func (bf *readOnlyBlockFile) TruncateBlocks(targetBlock uint64) error {
+	return ErrReadOnly
+}
+
+func (bf *readOnlyBlockFile) TruncateTail(firstKeptBlock uint64) error {
+	return ErrReadOnly
+}
+
+// Verify verifies the items of every table loaded when it starts.
+func (bf *readOnlyBlockFile) Verify(ctx context.Context) (map[string][]uint64, error) {
+	corrupted := make(map[string][]uint64)
+	for _, name := range bf.tableNames {
+		items, err := bf.verifyTable(ctx, bf.tables[name])
+		if len(items) > 0 {
+			corrupted[name] = items
+		}
+		if err != nil {
+			return corrupted, errors.Wrapf(err, "failed to verify table %s", name)
+		}
+	}
+	return corrupted, nil
+}
+
+// verifyTable verifies the table, the writer waits for it to truncate or remove files.
+func (bf *readOnlyBlockFile) verifyTable(ctx context.Context, table *BlockTable) ([]uint64, error) {
+	if err := bf.readersLock.rlock(); err != nil {
+		return nil, errors.Wrap(err, "failed to lock block file")
+	}
+	defer bf.readersLock.runlock()
+
+	if err := table.refresh(); err != nil {
+		return nil, errors.Wrap(err, "failed to refresh table")
+	}
+	return table.verify(ctx)
+}
+
+// Sync does nothing, the block file is synced by its writer.
+func (bf *readOnlyBlockFile) Sync() error {
+	return nil
+}
+
+func (bf *readOnlyBlockFile) Close() error {
+	var errs []error
+	bf.closeOnce.Do(func() {
+		for _, table := range bf.tables {
+			if err := table.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := bf.commitFile.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := bf.readersLock.close(); err != nil {
+			errs = append(errs, err)
+		}
+	})
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// openReadOnlyTable opens the table for reading without repairing it, so it requires
+// the index of the current version.
+func openReadOnlyTable(path string, name string, logger logrus.FieldLogger) (*BlockTable, error) {
+	table := &BlockTable{
+		files:  make(map[uint32]*os.File),
+		name:   name,
+		path:   path,
+		logger: logger,
+	}
+	if err := table.reload(); err != nil {
+		_ = table.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+// reload reopens the files of the read-only table. The data files deleted by a concurrent
+// tail truncation are no longer referenced by the rewritten index, which is opened again.
+func (b *BlockTable) reload() (err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for attempt := 0; attempt < readOnlyLoadAttempts; attempt++ {
+		if err = b.load(); !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+	}
+	return err
+}
+
+// refresh loads the items appended since the last load, the table is reloaded if
+// the writer replaced the index or the checksums on a tail truncation.
+func (b *BlockTable) refresh() error {
+	b.lock.Lock()
+	replaced, err := b.replaced()
+	if err == nil && !replaced {
+		err = b.loadItems()
+	}
+	b.lock.Unlock()
+	if err != nil || replaced {
+		return b.reload()
+	}
+	return nil
+}
+
+// replaced reports whether the index or the checksums were replaced since they were opened.
+func (b *BlockTable) replaced() (bool, error) {
+	for _, f := range []*os.File{b.index, b.checksums} {
+		opened, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		current, err := os.Stat(f.Name())
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !os.SameFile(opened, current) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// load opens the index, the checksums and the data files, the lock must be held.
+func (b *BlockTable) load() error {
+	for _, f := range []*os.File{b.index, b.checksums} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+	b.index, b.checksums, b.head = nil, nil, nil
+	for num, f := range b.files {
+		delete(b.files, num)
+		_ = f.Close()
+	}
+
+	index, err := openBlockFileForReadOnly(indexPath(b.path, b.name))
+	if err != nil {
+		return err
+	}
+	b.index = index
+	header := make([]byte, indexHeaderSize)
+	if _, err := b.index.ReadAt(header, 0); err != nil {
+		return errors.Wrapf(err, "table %s: failed to read index header", b.name)
+	}
+	if err := checkIndexHeader(header); err != nil {
+		return errors.Wrapf(err, "table %s", b.name)
+	}
+	tail, err := b.readEntry(0)
+	if err != nil {
+		return errors.Wrapf(err, "table %s: failed to read tail", b.name)
+	}
+	b.tailId, b.itemOffset = tail.filenum, tail.offset
+
+	checksums, err := openBlockFileForReadOnly(b.checksumPath())
+	if err != nil {
+		return err
+	}
+	b.checksums = checksums
+	first := make([]byte, checksumHeaderSize)
+	if _, err := b.checksums.ReadAt(first, 0); err != nil {
+		return errors.Wrapf(err, "table %s: failed to read checksums header", b.name)
+	}
+	b.checksumFirst = binary.BigEndian.Uint64(first)
+	return b.loadItems()
+}
+
+// loadItems reads the number of the indexed items and opens their data files, a torn
+// entry being written is ignored. The lock must be held.
+func (b *BlockTable) loadItems() error {
+	stat, err := b.index.Stat()
+	if err != nil {
+		return err
+	}
+	entries := uint64(stat.Size()-indexHeaderSize) / indexEntrySize
+	headId := b.tailId
+	if entries > 1 {
+		last, err := b.readEntry(entries - 1)
+		if err != nil {
+			return err
+		}
+		headId = last.filenum
+	}
+	// the files above the head were truncated by the writer
+	b.releaseFilesAfter(headId, false)
+	for num := b.tailId; num <= headId; num++ {
+		if _, err := b.openFile(num, openBlockFileForReadOnly); err != nil {
+			return err
+		}
+	}
+	b.head, b.headId = b.files[headId], headId
+	atomic.StoreUint64(&b.items, b.itemOffset+entries-1)
+	return nil
+}
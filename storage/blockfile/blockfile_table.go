@@ -1,11 +1,12 @@
 package blockfile
 
 import (
-	"encoding/binary"
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 
@@ -18,7 +19,7 @@ type BlockTable struct {
 
 	name        string
 	path        string
-	maxFileSize uint32 // Max file size for data-files
+	maxFileSize uint64 // Max file size for data-files
 
 	head   *os.File            // File descriptor for the data head of the table
 	index  *os.File            // File description
@@ -26,42 +27,27 @@ type BlockTable struct {
 	headId uint32              // number of the currently active head file
 	tailId uint32              // number of the earliest file
 
-	headBytes  uint32 // Number of bytes written to the head file
-	itemOffset uint32 // Offset (number of discarded items)
+	headBytes  uint64 // Number of bytes written to the head file
+	itemOffset uint64 // Offset (number of discarded items)
+
+	compression Compression // Compression of the appended items
+
+	checksums     *os.File // Checksum sidecar of the items
+	checksumFirst uint64   // First checksummed item
 
 	logger     logrus.FieldLogger
 	lock       sync.RWMutex // Mutex protecting the data file descriptors
 	appendLock sync.Mutex   // Mutex protect data appending race
 }
 
-type indexEntry struct {
-	filenum uint32 // stored as uint16 ( 2 bytes)
-	offset  uint32 // stored as uint32 ( 4 bytes)
-}
-
-const indexEntrySize = 6
-
-// unmarshallBinary deserializes binary b into the rawIndex entry.
-func (i *indexEntry) unmarshalBinary(b []byte) error {
-	i.filenum = uint32(binary.BigEndian.Uint16(b[:2]))
-	i.offset = binary.BigEndian.Uint32(b[2:6])
-	return nil
-}
-
-// marshallBinary serializes the rawIndex entry into binary.
-func (i *indexEntry) marshallBinary() []byte {
-	b := make([]byte, indexEntrySize)
-	binary.BigEndian.PutUint16(b[:2], uint16(i.filenum))
-	binary.BigEndian.PutUint32(b[2:6], i.offset)
-	return b
-}
-
-func newTable(path string, name string, maxFilesize uint32, logger logrus.FieldLogger) (*BlockTable, error) {
+func newTable(path string, name string, maxFilesize uint64, logger logrus.FieldLogger) (*BlockTable, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, err
 	}
-	idxName := fmt.Sprintf("%s.ridx", name)
-	offsets, err := openBlockFileForAppend(filepath.Join(path, idxName))
+	if err := upgradeIndex(path, name, logger); err != nil {
+		return nil, err
+	}
+	offsets, err := openBlockFileForAppend(indexPath(path, name))
 	if err != nil {
 		return nil, err
 	}
@@ -86,12 +72,32 @@ func (b *BlockTable) repair() error {
 	if err != nil {
 		return err
 	}
-	if stat.Size() == 0 {
-		if _, err := b.index.Write(buffer); err != nil {
+	if stat.Size() < indexHeaderSize {
+		// new or torn index without any item
+		if err := truncateBlockFile(b.index, 0); err != nil {
 			return err
 		}
+		if _, err := b.index.Write(append(indexHeader(), buffer...)); err != nil {
+			return err
+		}
+	} else {
+		header := make([]byte, indexHeaderSize)
+		if _, err := b.index.ReadAt(header, 0); err != nil {
+			return err
+		}
+		if err := checkIndexHeader(header); err != nil {
+			return errors.Wrapf(err, "table %s", b.name)
+		}
+		if stat.Size() == indexHeaderSize {
+			if _, err := b.index.Write(buffer); err != nil {
+				return err
+			}
+		}
+	}
+	if stat, err = b.index.Stat(); err != nil {
+		return err
 	}
-	if remainder := stat.Size() % indexEntrySize; remainder != 0 {
+	if remainder := (stat.Size() - indexHeaderSize) % indexEntrySize; remainder != 0 {
 		err := truncateBlockFile(b.index, stat.Size()-remainder)
 		if err != nil {
 			return err
@@ -100,7 +106,7 @@ func (b *BlockTable) repair() error {
 	if stat, err = b.index.Stat(); err != nil {
 		return err
 	}
-	offsetsSize := stat.Size()
+	offsetsSize := stat.Size() - indexHeaderSize
 
 	// Open the head file
 	var (
@@ -111,7 +117,7 @@ func (b *BlockTable) repair() error {
 	)
 	// Read index zero, determine what file is the earliest
 	// and what item offset to use
-	_, err = b.index.ReadAt(buffer, 0)
+	_, err = b.index.ReadAt(buffer, entryOffset(0))
 	if err != nil {
 		return err
 	}
@@ -123,7 +129,7 @@ func (b *BlockTable) repair() error {
 	b.tailId = firstIndex.filenum
 	b.itemOffset = firstIndex.offset
 
-	_, err = b.index.ReadAt(buffer, offsetsSize-indexEntrySize)
+	_, err = b.index.ReadAt(buffer, indexHeaderSize+offsetsSize-indexEntrySize)
 	if err != nil {
 		return err
 	}
@@ -131,6 +137,10 @@ func (b *BlockTable) repair() error {
 	if err != nil {
 		return err
 	}
+	if offsetsSize == indexEntrySize {
+		// no item after the tail, the tail file is empty
+		lastIndex = indexEntry{filenum: b.tailId}
+	}
 	b.head, err = b.openFile(lastIndex.filenum, openBlockFileForAppend)
 	if err != nil {
 		return err
@@ -160,7 +170,7 @@ func (b *BlockTable) repair() error {
 				"stored":  contentSize,
 			}).Warn("Truncating dangling indexes")
 			offsetsSize -= indexEntrySize
-			_, err = b.index.ReadAt(buffer, offsetsSize-indexEntrySize)
+			_, err = b.index.ReadAt(buffer, indexHeaderSize+offsetsSize-indexEntrySize)
 			if err != nil {
 				return err
 			}
@@ -169,6 +179,9 @@ func (b *BlockTable) repair() error {
 			if err != nil {
 				return err
 			}
+			if offsetsSize == indexEntrySize {
+				newLastIndex = indexEntry{filenum: b.tailId}
+			}
 			// We might have slipped back into an earlier head-file here
 			if newLastIndex.filenum != lastIndex.filenum {
 				// Release earlier opened file
@@ -187,6 +200,15 @@ func (b *BlockTable) repair() error {
 			contentExp = int64(lastIndex.offset)
 		}
 	}
+	// Drop the dangling indexes
+	if stat, err = b.index.Stat(); err != nil {
+		return err
+	}
+	if stat.Size() > indexHeaderSize+offsetsSize {
+		if err := truncateBlockFile(b.index, indexHeaderSize+offsetsSize); err != nil {
+			return err
+		}
+	}
 	// Ensure all reparation changes have been written to disk
 	if err := b.index.Sync(); err != nil {
 		return err
@@ -195,14 +217,17 @@ func (b *BlockTable) repair() error {
 		return err
 	}
 	// Update the item and byte counters and return
-	b.items = uint64(b.itemOffset) + uint64(offsetsSize/indexEntrySize-1) // last indexEntry points to the end of the data file
-	b.headBytes = uint32(contentSize)
+	b.items = b.itemOffset + uint64(offsetsSize/indexEntrySize-1) // last indexEntry points to the end of the data file
+	b.headBytes = uint64(contentSize)
 	b.headId = lastIndex.filenum
 
 	// Close opened files and preopen all files
 	if err := b.preopen(); err != nil {
 		return err
 	}
+	if err := b.repairChecksums(); err != nil {
+		return err
+	}
 	b.logger.WithFields(logrus.Fields{
 		"items": b.items,
 		"size":  b.headBytes,
@@ -219,23 +244,31 @@ func (b *BlockTable) truncate(items uint64) error {
 	if existing <= items {
 		return nil
 	}
+	if items < b.itemOffset {
+		return errors.Wrapf(ErrBlockPruned, "truncate to %d items below tail %d", items, b.itemOffset)
+	}
 
 	b.logger.WithFields(logrus.Fields{
 		"items": existing,
 		"limit": items,
 	}).Warn("Truncating block file")
-	if err := truncateBlockFile(b.index, int64(items+1)*indexEntrySize); err != nil {
+	relative := items - b.itemOffset
+	if err := truncateBlockFile(b.index, entryOffset(relative+1)); err != nil {
 		return err
 	}
 	// Calculate the new expected size of the data file and truncate it
-	buffer := make([]byte, indexEntrySize)
-	if _, err := b.index.ReadAt(buffer, int64(items*indexEntrySize)); err != nil {
-		return err
-	}
 	var expected indexEntry
-	err := expected.unmarshalBinary(buffer)
-	if err != nil {
-		return err
+	if relative == 0 {
+		// the first index entry holds the tail, the tail file is emptied
+		expected = indexEntry{filenum: b.tailId}
+	} else {
+		buffer := make([]byte, indexEntrySize)
+		if _, err := b.index.ReadAt(buffer, entryOffset(relative)); err != nil {
+			return err
+		}
+		if err := expected.unmarshalBinary(buffer); err != nil {
+			return err
+		}
 	}
 
 	// We might need to truncate back to older files
@@ -256,13 +289,166 @@ func (b *BlockTable) truncate(items uint64) error {
 	if err := truncateBlockFile(b.head, int64(expected.offset)); err != nil {
 		return err
 	}
+	if err := b.truncateChecksums(items); err != nil {
+		return err
+	}
 	// All data files truncated, set internal counters and return
 	atomic.StoreUint64(&b.items, items)
-	atomic.StoreUint32(&b.headBytes, expected.offset)
+	atomic.StoreUint64(&b.headBytes, expected.offset)
 
 	return nil
 }
 
+// truncateTail deletes the data files holding only items below tail. The items of the
+// file holding tail are kept, so the items from the first one of the file stay readable.
+// The index is rewritten to start at the new tail file before the old files are deleted,
+// a crash in between leaves unreferenced data files behind, which are never read.
+func (b *BlockTable) truncateTail(tail uint64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	items := atomic.LoadUint64(&b.items)
+	if tail > items {
+		return errors.Errorf("truncate tail %d beyond %d items", tail, items)
+	}
+	if tail <= b.itemOffset {
+		return nil
+	}
+
+	newTailId := b.headId
+	if tail < items {
+		entry, err := b.readEntry(tail - b.itemOffset + 1)
+		if err != nil {
+			return err
+		}
+		newTailId = entry.filenum
+	}
+	if newTailId == b.tailId {
+		return nil
+	}
+
+	// binary search the first item stored in the new tail file
+	var searchErr error
+	first := b.itemOffset + uint64(sort.Search(int(items-b.itemOffset), func(i int) bool {
+		entry, err := b.readEntry(uint64(i) + 1)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return entry.filenum >= newTailId
+	}))
+	if searchErr != nil {
+		return searchErr
+	}
+	b.logger.WithFields(logrus.Fields{
+		"tail":      tail,
+		"items":     items,
+		"deleted":   first - b.itemOffset,
+		"tail_file": newTailId,
+	}).Info("Truncating block file tail")
+
+	// copy the index entries of the kept items after the new tail entry
+	stat, err := b.index.Stat()
+	if err != nil {
+		return err
+	}
+	keptOffset := entryOffset(first - b.itemOffset + 1)
+	kept := make([]byte, stat.Size()-keptOffset)
+	if _, err := b.index.ReadAt(kept, keptOffset); err != nil {
+		return err
+	}
+	tailEntry := indexEntry{filenum: newTailId, offset: first}
+	indexPath := indexPath(b.path, b.name)
+	data := append(append(indexHeader(), tailEntry.marshallBinary()...), kept...)
+	if err := writeFileSync(indexPath+".tmp", data); err != nil {
+		return err
+	}
+	if err := b.index.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(indexPath+".tmp", indexPath); err != nil {
+		return err
+	}
+	if b.index, err = openBlockFileForAppend(indexPath); err != nil {
+		return err
+	}
+
+	for num := b.tailId; num < newTailId; num++ {
+		if f, exist := b.files[num]; exist {
+			delete(b.files, num)
+			_ = f.Close()
+			if err := os.Remove(f.Name()); err != nil {
+				b.logger.WithFields(logrus.Fields{
+					"file": f.Name(),
+					"err":  err,
+				}).Warn("Failed to remove pruned data file")
+			}
+		}
+	}
+	b.tailId = newTailId
+	b.itemOffset = first
+	return b.truncateChecksumsTail(first)
+}
+
+// backfill fills the empty table with empty items in [from, to).
+func (b *BlockTable) backfill(from, to uint64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.items != 0 || b.headBytes != 0 {
+		return errors.New("backfill non-empty table")
+	}
+	indexPath := indexPath(b.path, b.name)
+	f, err := os.OpenFile(indexPath+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	tailEntry := indexEntry{filenum: b.headId, offset: from}
+	_, err = w.Write(append(indexHeader(), tailEntry.marshallBinary()...))
+	empty := (&indexEntry{filenum: b.headId}).marshallBinary()
+	for i := from; i < to && err == nil; i++ {
+		_, err = w.Write(empty)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.index.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(indexPath+".tmp", indexPath); err != nil {
+		return err
+	}
+	if b.index, err = openBlockFileForAppend(indexPath); err != nil {
+		return err
+	}
+	b.tailId = b.headId
+	b.itemOffset = from
+	atomic.StoreUint64(&b.items, to)
+	return b.resetChecksums(to)
+}
+
+// readEntry reads the index entry at the given position, position 0 holds the tail.
+func (b *BlockTable) readEntry(position uint64) (indexEntry, error) {
+	var entry indexEntry
+	buffer := make([]byte, indexEntrySize)
+	if _, err := b.index.ReadAt(buffer, entryOffset(position)); err != nil {
+		return entry, err
+	}
+	err := entry.unmarshalBinary(buffer)
+	return entry, err
+}
+
 func (b *BlockTable) Retrieve(item uint64) ([]byte, error) {
 	b.lock.RLock()
 
@@ -274,58 +460,204 @@ func (b *BlockTable) Retrieve(item uint64) ([]byte, error) {
 		b.lock.RUnlock()
 		return nil, errors.New("out of bounds")
 	}
-	if uint64(b.itemOffset) > item {
+	if b.itemOffset > item {
 		b.lock.RUnlock()
-		return nil, errors.New("out of bounds")
+		return nil, ErrBlockPruned
+	}
+	blob, compression, err := b.readItem(item)
+	if err == nil {
+		err = b.verifyChecksum(item, blob)
+	}
+	b.lock.RUnlock()
+	if err != nil {
+		return nil, err
 	}
-	startOffset, endOffset, filenum, err := b.getBounds(item - uint64(b.itemOffset))
+
+	data, err := decompress(compression, blob)
 	if err != nil {
+		return nil, errors.Wrapf(ErrCorrupted, "table %s item %d: %v", b.name, item, err)
+	}
+	return data, nil
+}
+
+// RetrieveRange returns at most count items from the given one, reading their index entries
+// and the data of every file at once. The items are limited to maxBytes of stored data,
+// 0 means unlimited, but the first item is always returned.
+func (b *BlockTable) RetrieveRange(from, count, maxBytes uint64) ([][]byte, error) {
+	b.lock.RLock()
+
+	if b.index == nil || b.head == nil {
 		b.lock.RUnlock()
+		return nil, errors.New("closed")
+	}
+	items := atomic.LoadUint64(&b.items)
+	if items <= from {
+		b.lock.RUnlock()
+		return nil, errors.New("out of bounds")
+	}
+	if b.itemOffset > from {
+		b.lock.RUnlock()
+		return nil, ErrBlockPruned
+	}
+	if count == 0 {
+		b.lock.RUnlock()
+		return nil, nil
+	}
+	if count > items-from {
+		count = items - from
+	}
+	blobs, compressions, err := b.readRange(from, count, maxBytes)
+	b.lock.RUnlock()
+	if err != nil {
 		return nil, err
 	}
+
+	for i, blob := range blobs {
+		if blobs[i], err = decompress(compressions[i], blob); err != nil {
+			return nil, errors.Wrapf(ErrCorrupted, "table %s item %d: %v", b.name, from+uint64(i), err)
+		}
+	}
+	return blobs, nil
+}
+
+// readRange reads and verifies the stored bytes of the items in range, the lock must be held.
+func (b *BlockTable) readRange(from, count, maxBytes uint64) ([][]byte, []Compression, error) {
+	relative := from - b.itemOffset
+	buffer := make([]byte, (count+1)*indexEntrySize)
+	if _, err := b.index.ReadAt(buffer, entryOffset(relative)); err != nil {
+		return nil, nil, err
+	}
+	entries := make([]indexEntry, count+1)
+	for i := range entries {
+		if err := entries[i].unmarshalBinary(buffer[i*indexEntrySize:]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if relative == 0 {
+		// the first index entry holds the tail, the first item starts its file
+		entries[0] = indexEntry{filenum: entries[1].filenum}
+	}
+
+	var (
+		starts = make([]uint64, 0, count)
+		total  uint64
+	)
+	for i := uint64(0); i < count; i++ {
+		start := entries[i].offset
+		if entries[i].filenum != entries[i+1].filenum {
+			start = 0
+		}
+		if start > entries[i+1].offset {
+			return nil, nil, errors.Wrapf(ErrCorrupted, "table %s item %d: invalid bounds [%d, %d]", b.name, from+i, start, entries[i+1].offset)
+		}
+		size := entries[i+1].offset - start
+		if maxBytes > 0 && i > 0 && total+size > maxBytes {
+			break
+		}
+		total += size
+		starts = append(starts, start)
+	}
+	count = uint64(len(starts))
+
+	blobs := make([][]byte, count)
+	compressions := make([]Compression, count)
+	// read the consecutive items of every data file at once
+	for i := uint64(0); i < count; {
+		filenum := entries[i+1].filenum
+		j := i + 1
+		for j < count && entries[j+1].filenum == filenum {
+			j++
+		}
+		dataFile, exist := b.files[filenum]
+		if !exist {
+			return nil, nil, fmt.Errorf("missing data file %d", filenum)
+		}
+		data := make([]byte, entries[j].offset-starts[i])
+		if _, err := dataFile.ReadAt(data, int64(starts[i])); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, nil, errors.Wrapf(ErrCorrupted, "table %s items [%d, %d): %v", b.name, from+i, from+j, err)
+			}
+			return nil, nil, err
+		}
+		for k := i; k < j; k++ {
+			blobs[k] = data[starts[k]-starts[i] : entries[k+1].offset-starts[i] : entries[k+1].offset-starts[i]]
+			compressions[k] = entries[k+1].compression
+		}
+		i = j
+	}
+
+	if err := b.verifyChecksums(from, blobs); err != nil {
+		return nil, nil, err
+	}
+	return blobs, compressions, nil
+}
+
+// readItem reads the stored bytes of the item, the lock must be held.
+func (b *BlockTable) readItem(item uint64) ([]byte, Compression, error) {
+	startOffset, endOffset, filenum, compression, err := b.getBounds(item - b.itemOffset)
+	if err != nil {
+		return nil, 0, err
+	}
 	dataFile, exist := b.files[filenum]
 	if !exist {
-		b.lock.RUnlock()
-		return nil, fmt.Errorf("missing data file %d", filenum)
+		return nil, 0, fmt.Errorf("missing data file %d", filenum)
+	}
+	if startOffset > endOffset {
+		return nil, 0, errors.Wrapf(ErrCorrupted, "table %s item %d: invalid bounds [%d, %d]", b.name, item, startOffset, endOffset)
 	}
 	blob := make([]byte, endOffset-startOffset)
 	if _, err := dataFile.ReadAt(blob, int64(startOffset)); err != nil {
-		b.lock.RUnlock()
-		return nil, err
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.Wrapf(ErrCorrupted, "table %s item %d: %v", b.name, item, err)
+		}
+		return nil, 0, err
 	}
-	b.lock.RUnlock()
-
-	return blob, nil
+	return blob, compression, nil
 }
 
 func (b *BlockTable) Append(item uint64, blob []byte) error {
 	b.appendLock.Lock()
 	defer b.appendLock.Unlock()
-	return b.doBatchAppend(item, blob)
+	return b.doBatchAppend(item, b.compressItems([][]byte{blob}))
 }
 
 func (b *BlockTable) BatchAppend(item uint64, listOfBlob [][]byte) error {
 	b.appendLock.Lock()
 	defer b.appendLock.Unlock()
-	totalBLen := uint32(0)
-	for _, blob := range listOfBlob {
-		totalBLen += uint32(len(blob))
+	items := b.compressItems(listOfBlob)
+	totalBLen := uint64(0)
+	for _, it := range items {
+		totalBLen += uint64(len(it.blob))
 	}
 	if b.headBytes+totalBLen > b.maxFileSize { // for save storage space
-		for _, blob := range listOfBlob {
-			err := b.doBatchAppend(item, blob)
+		for i := range items {
+			err := b.doBatchAppend(item, items[i:i+1])
 			if err != nil {
 				return err
 			}
 			item = b.items
 		}
 	} else {
-		return b.doBatchAppend(item, listOfBlob...)
+		return b.doBatchAppend(item, items)
 	}
 	return nil
 }
 
-func (b *BlockTable) doBatchAppend(item uint64, listOfBlob ...[]byte) error {
+// tableItem is an item to append, compressed with its compression.
+type tableItem struct {
+	blob        []byte
+	compression Compression
+}
+
+func (b *BlockTable) compressItems(listOfBlob [][]byte) []tableItem {
+	items := make([]tableItem, len(listOfBlob))
+	for i, blob := range listOfBlob {
+		items[i].blob, items[i].compression = compress(b.compression, blob)
+	}
+	return items
+}
+
+func (b *BlockTable) doBatchAppend(item uint64, items []tableItem) error {
 	b.lock.RLock()
 	if b.index == nil || b.head == nil {
 		b.lock.RUnlock()
@@ -337,15 +669,19 @@ func (b *BlockTable) doBatchAppend(item uint64, listOfBlob ...[]byte) error {
 	}
 	var mergeBlobBytes []byte
 
-	for _, blob := range listOfBlob {
-		mergeBlobBytes = append(mergeBlobBytes, blob...)
+	for _, it := range items {
+		mergeBlobBytes = append(mergeBlobBytes, it.blob...)
 	}
-	totalBLen := uint32(len(mergeBlobBytes))
+	totalBLen := uint64(len(mergeBlobBytes))
 
 	if b.headBytes+totalBLen > b.maxFileSize {
 		b.lock.RUnlock()
 		b.lock.Lock()
 		nextID := atomic.LoadUint32(&b.headId) + 1
+		if nextID == 0 {
+			b.lock.Unlock()
+			return errors.Errorf("too many data files of table %s", b.name)
+		}
 		// We open the next file in truncated mode -- if this file already
 		// exists, we need to start over from scratch on it
 		newHead, err := b.openFile(nextID, openBlockFileTruncated)
@@ -353,64 +689,122 @@ func (b *BlockTable) doBatchAppend(item uint64, listOfBlob ...[]byte) error {
 			b.lock.Unlock()
 			return err
 		}
-		// Close old file, and reopen in RDONLY mode
+		// Sync and close old file, and reopen in RDONLY mode, a later Sync only syncs the new head
+		if err := b.head.Sync(); err != nil {
+			b.lock.Unlock()
+			return err
+		}
 		b.releaseFile(b.headId)
 		_, err = b.openFile(b.headId, openBlockFileForReadOnly)
 		if err != nil {
+			b.lock.Unlock()
 			return err
 		}
 
 		// Swap out the current head
 		b.head = newHead
-		atomic.StoreUint32(&b.headBytes, 0)
+		atomic.StoreUint64(&b.headBytes, 0)
 		atomic.StoreUint32(&b.headId, nextID)
 		b.lock.Unlock()
 		b.lock.RLock()
 	}
 
 	defer b.lock.RUnlock()
+	headBytes := atomic.LoadUint64(&b.headBytes)
+	if err := b.writeItems(mergeBlobBytes, items); err != nil {
+		if rerr := b.rollback(item, headBytes); rerr != nil {
+			b.logger.WithFields(logrus.Fields{
+				"table": b.name,
+				"err":   rerr,
+			}).Error("Failed to roll back block table")
+		}
+		return err
+	}
+	atomic.AddUint64(&b.items, uint64(len(items)))
+	return nil
+}
+
+// writeItems writes the items to the head file, their checksums and then their index entries.
+func (b *BlockTable) writeItems(mergeBlobBytes []byte, items []tableItem) error {
 	if _, err := b.head.Write(mergeBlobBytes); err != nil {
 		return err
 	}
+	var checksums []byte
+	for _, it := range items {
+		checksums = append(checksums, checksumBytes(it.blob)...)
+	}
+	if _, err := b.checksums.Write(checksums); err != nil {
+		return err
+	}
 	var mergeIdxBytes []byte
-	for _, blob := range listOfBlob {
-		newOffset := atomic.AddUint32(&b.headBytes, uint32(len(blob)))
+	for _, it := range items {
+		newOffset := atomic.AddUint64(&b.headBytes, uint64(len(it.blob)))
 		idx := indexEntry{
-			filenum: atomic.LoadUint32(&b.headId),
-			offset:  newOffset,
+			filenum:     atomic.LoadUint32(&b.headId),
+			compression: it.compression,
+			offset:      newOffset,
 		}
 		mergeIdxBytes = append(mergeIdxBytes, idx.marshallBinary()...)
 	}
-	// Write indexEntry
-	_, _ = b.index.Write(mergeIdxBytes)
-	atomic.AddUint64(&b.items, uint64(len(listOfBlob)))
-	return nil
+	_, err := b.index.Write(mergeIdxBytes)
+	return err
 }
 
-func (b *BlockTable) getBounds(item uint64) (uint32, uint32, uint32, error) {
+// rollback drops the partially written items of a failed append, so that the table
+// holds the given number of items and head bytes again.
+func (b *BlockTable) rollback(items, headBytes uint64) error {
+	atomic.StoreUint64(&b.headBytes, headBytes)
+	if err := truncateBlockFile(b.head, int64(headBytes)); err != nil {
+		return err
+	}
+	if err := b.truncateChecksums(items); err != nil {
+		return err
+	}
+	return truncateBlockFile(b.index, entryOffset(items-b.itemOffset+1))
+}
+
+// Sync flushes the head file, the checksums and the index to disk, in that order,
+// so that the synced index entries never point at unsynced data.
+func (b *BlockTable) Sync() error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.index == nil || b.head == nil {
+		return errors.New("closed")
+	}
+	if err := b.head.Sync(); err != nil {
+		return err
+	}
+	if err := b.checksums.Sync(); err != nil {
+		return err
+	}
+	return b.index.Sync()
+}
+
+// getBounds returns the bounds, the data file and the compression of the item.
+func (b *BlockTable) getBounds(item uint64) (uint64, uint64, uint32, Compression, error) {
 	buffer := make([]byte, indexEntrySize)
 	var startIdx, endIdx indexEntry
-	if _, err := b.index.ReadAt(buffer, int64((item+1)*indexEntrySize)); err != nil {
-		return 0, 0, 0, err
+	if _, err := b.index.ReadAt(buffer, entryOffset(item+1)); err != nil {
+		return 0, 0, 0, 0, err
 	}
 	if err := endIdx.unmarshalBinary(buffer); err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, 0, err
 	}
 	if item != 0 {
-		if _, err := b.index.ReadAt(buffer, int64(item*indexEntrySize)); err != nil {
-			return 0, 0, 0, err
+		if _, err := b.index.ReadAt(buffer, entryOffset(item)); err != nil {
+			return 0, 0, 0, 0, err
 		}
 		if err := startIdx.unmarshalBinary(buffer); err != nil {
-			return 0, 0, 0, err
+			return 0, 0, 0, 0, err
 		}
 	} else {
 		// the first reading
-		return 0, endIdx.offset, endIdx.filenum, nil
+		return 0, endIdx.offset, endIdx.filenum, endIdx.compression, nil
 	}
 	if startIdx.filenum != endIdx.filenum {
-		return 0, endIdx.offset, endIdx.filenum, nil
+		return 0, endIdx.offset, endIdx.filenum, endIdx.compression, nil
 	}
-	return startIdx.offset, endIdx.offset, endIdx.filenum, nil
+	return startIdx.offset, endIdx.offset, endIdx.filenum, endIdx.compression, nil
 }
 
 func (b *BlockTable) preopen() (err error) {
@@ -448,6 +842,12 @@ func (b *BlockTable) Close() error {
 		errs = append(errs, err)
 	}
 	b.index = nil
+	if b.checksums != nil {
+		if err := b.checksums.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		b.checksums = nil
+	}
 
 	for _, f := range b.files {
 		if err := f.Close(); err != nil {
@@ -492,6 +892,23 @@ func truncateBlockFile(file *os.File, size int64) error {
 	return nil
 }
 
+// writeFileSync writes data to the file and syncs it.
+func writeFileSync(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func openBlockFileForAppend(filename string) (*os.File, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
@@ -2,16 +2,22 @@ package blockfile
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"math/big"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/axiomesh/axiom-kit/log"
+	"github.com/axiomesh/axiom-kit/storage/kv"
 	"github.com/axiomesh/axiom-kit/types"
 )
 
@@ -355,3 +361,1125 @@ func assertFileSize(f string, size int64) error {
 
 	return nil
 }
+
+func TestBlockTableTruncateTail(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewWithModule("blockfile_test")
+	f, err := newTable(dir, "tail", 50, logger)
+	assert.Nil(t, err)
+	// 3 items of 15 bytes per file
+	for x := 0; x < 30; x++ {
+		assert.Nil(t, f.Append(uint64(x), getChunk(15, x)))
+	}
+
+	// the items sharing the file of the tail are kept
+	assert.Nil(t, f.truncateTail(7))
+	assert.EqualValues(t, 2, f.tailId)
+	assert.EqualValues(t, 6, f.itemOffset)
+	_, err = os.Stat(filepath.Join(dir, "tail.0001.rdat"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = f.Retrieve(5)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	for x := 6; x < 30; x++ {
+		got, err := f.Retrieve(uint64(x))
+		assert.Nil(t, err)
+		assert.EqualValues(t, getChunk(15, x), got)
+	}
+
+	// truncating within the tail file is a no-op
+	assert.Nil(t, f.truncateTail(8))
+	assert.EqualValues(t, 6, f.itemOffset)
+	assert.Error(t, f.truncateTail(31))
+	assert.Nil(t, f.Close())
+
+	f, err = newTable(dir, "tail", 50, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 30, f.items)
+	assert.EqualValues(t, 6, f.itemOffset)
+	got, err := f.Retrieve(6)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 6), got)
+
+	// head truncation works on top of the tail, but not below it
+	assert.ErrorIs(t, f.truncate(5), ErrBlockPruned)
+	assert.Nil(t, f.truncate(7))
+	_, err = f.Retrieve(7)
+	assert.ErrorContains(t, err, "out of bounds")
+	assert.Nil(t, f.truncate(6))
+	assert.Nil(t, f.Append(6, getChunk(15, 0xEE)))
+	assert.Nil(t, f.Close())
+
+	f, err = newTable(dir, "tail", 50, logger)
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.EqualValues(t, 7, f.items)
+	got, err = f.Retrieve(6)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 0xEE), got)
+
+	// pruning everything keeps the head file
+	assert.Nil(t, f.truncateTail(7))
+	assert.Nil(t, f.Append(7, getChunk(15, 7)))
+	got, err = f.Retrieve(7)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 7), got)
+}
+
+func TestBlockFileTruncateTail(t *testing.T) {
+	p := getStoragePath(t)
+	f, err := NewBlockFile(p, log.NewWithModule("blockfile_test"))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name string
+		f    BlockFile
+	}{
+		{
+			name: "file",
+			f:    f,
+		},
+		{
+			name: "memory",
+			f:    NewMemory(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			for i := uint64(0); i < 10; i++ {
+				data := []byte{byte(i)}
+				assert.Nil(t, f.AppendBlock(i, types.NewHash(data).Bytes(), data, data, data, data))
+			}
+
+			assert.Error(t, f.TruncateTail(11))
+			assert.Nil(t, f.TruncateTail(4))
+			for name := range BlockFileSchema {
+				_, err := f.Get(name, 3)
+				assert.ErrorIs(t, err, ErrBlockPruned)
+				data, err := f.Get(name, 4)
+				assert.Nil(t, err)
+				assert.EqualValues(t, []byte{4}, data)
+			}
+
+			assert.ErrorIs(t, f.TruncateBlocks(2), ErrBlockPruned)
+			assert.Nil(t, f.TruncateBlocks(5))
+			assert.EqualValues(t, 6, f.NextBlockNumber())
+			assert.Nil(t, f.AppendBlock(6, types.NewHash([]byte{6}).Bytes(), []byte{6}, []byte{6}, []byte{6}, []byte{6}))
+		})
+	}
+
+	assert.Nil(t, f.Close())
+	f, err = NewBlockFile(p, log.NewWithModule("blockfile_test"))
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.EqualValues(t, 7, f.NextBlockNumber())
+	_, err = f.Get(BlockFileHeaderTable, 3)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	header, err := f.Get(BlockFileHeaderTable, 6)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte{6}, header)
+}
+
+func TestBlockFileSchema(t *testing.T) {
+	schema := []string{BlockFileHeaderTable, BlockFileTXsTable, BlockFileExtraTable, BlockFileReceiptsTable, BlockFileHashTable, "traces"}
+	f, err := NewBlockFile(getStoragePath(t), log.NewWithModule("blockfile_test"), WithSchema(schema...))
+	assert.Nil(t, err)
+	defer f.Close()
+
+	tests := []struct {
+		name string
+		f    BlockFile
+	}{
+		{
+			name: "file",
+			f:    f,
+		},
+		{
+			name: "memory",
+			f:    NewMemory(WithSchema(schema...)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			// the traces table is not filled by AppendBlock
+			err := f.AppendBlock(0, []byte("hash0"), []byte("header0"), nil, nil, nil)
+			assert.ErrorContains(t, err, "record misses table traces")
+
+			record := Record{"traces": []byte("traces0")}
+			for _, name := range schema[:5] {
+				record[name] = []byte(name + "0")
+			}
+			assert.Nil(t, f.AppendRecord(0, record))
+			record["unknown"] = nil
+			assert.ErrorContains(t, f.AppendRecord(1, record), "unknown table")
+			assert.EqualValues(t, 1, f.NextBlockNumber())
+
+			var records []Record
+			for i := 1; i < 3; i++ {
+				record := Record{}
+				for _, name := range schema {
+					record[name] = []byte(fmt.Sprintf("%s%d", name, i))
+				}
+				records = append(records, record)
+			}
+			records[1]["traces"] = nil
+			assert.Nil(t, f.BatchAppendRecords(1, records))
+			assert.EqualValues(t, 3, f.NextBlockNumber())
+
+			for i := 0; i < 3; i++ {
+				hash, err := f.Get(BlockFileHashTable, uint64(i))
+				assert.Nil(t, err)
+				assert.EqualValues(t, fmt.Sprintf("hash%d", i), hash)
+			}
+			traces, err := f.Get("traces", 2)
+			assert.Nil(t, err)
+			assert.Empty(t, traces)
+		})
+	}
+}
+
+func TestBlockFileAddTable(t *testing.T) {
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	f, err := NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	for i := uint64(0); i < 10; i++ {
+		data := []byte{byte(i)}
+		assert.Nil(t, f.AppendBlock(i, types.NewHash(data).Bytes(), data, data, data, data))
+	}
+	assert.Nil(t, f.TruncateTail(2))
+	assert.Nil(t, f.Close())
+
+	// the hash table added to the existing data dir is backfilled
+	f, err = NewBlockFile(p, logger, WithSchema(BlockFileHeaderTable, BlockFileTXsTable, BlockFileExtraTable, BlockFileReceiptsTable, BlockFileHashTable))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 10, f.NextBlockNumber())
+	hash, err := f.Get(BlockFileHashTable, 9)
+	assert.Nil(t, err)
+	assert.Empty(t, hash)
+	_, err = f.Get(BlockFileHashTable, 1)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	hash10 := types.NewHash([]byte{10}).Bytes()
+	assert.Nil(t, f.AppendBlock(10, hash10, []byte{10}, []byte{10}, []byte{10}, []byte{10}))
+	assert.Nil(t, f.Close())
+
+	f, err = NewBlockFile(p, logger, WithSchema(BlockFileHeaderTable, BlockFileHashTable))
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.EqualValues(t, 11, f.NextBlockNumber())
+	hash, err = f.Get(BlockFileHashTable, 10)
+	assert.Nil(t, err)
+	assert.EqualValues(t, hash10, hash)
+	_, err = f.Get(BlockFileTXsTable, 10)
+	assert.ErrorContains(t, err, "unknown table")
+}
+
+func TestBlockFileCompression(t *testing.T) {
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	receipts := bytes.Repeat([]byte("receipt"), 100)
+	appendBlock := func(f BlockFile, number uint64) {
+		data := []byte{byte(number)}
+		assert.Nil(t, f.AppendBlock(number, types.NewHash(data).Bytes(), data, data, receipts, receipts))
+	}
+
+	// blocks written raw stay readable once the compression is enabled
+	f, err := NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	appendBlock(f, 0)
+	assert.Nil(t, f.Close())
+
+	f, err = NewBlockFile(p, logger, WithCompression(BlockFileReceiptsTable, CompressionSnappy), WithCompression(BlockFileTXsTable, CompressionZstd))
+	assert.Nil(t, err)
+	appendBlock(f, 1)
+	assert.Nil(t, f.BatchAppendBlock(2, [][]byte{{2}, {3}}, [][]byte{{2}, {3}}, [][]byte{{2}, {3}}, [][]byte{receipts, {}}, [][]byte{receipts, {}}))
+	assert.Nil(t, f.Close())
+
+	f, err = NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	defer f.Close()
+	bf := f.(*blockFile)
+	for _, table := range []string{BlockFileReceiptsTable, BlockFileTXsTable} {
+		for i := uint64(0); i < 3; i++ {
+			got, err := f.Get(table, i)
+			assert.Nil(t, err)
+			assert.EqualValues(t, receipts, got)
+		}
+		got, err := f.Get(table, 3)
+		assert.Nil(t, err)
+		assert.Empty(t, got)
+
+		entry, err := bf.tables[table].readEntry(1)
+		assert.Nil(t, err)
+		assert.EqualValues(t, CompressionNone, entry.compression)
+		entry, err = bf.tables[table].readEntry(2)
+		assert.Nil(t, err)
+		assert.NotEqualValues(t, CompressionNone, entry.compression)
+		assert.Less(t, entry.offset, uint64(2*len(receipts)))
+	}
+
+	_, err = NewBlockFile(getStoragePath(t), logger, WithCompression(BlockFileTXsTable, 3))
+	assert.ErrorIs(t, err, ErrUnknownCompression)
+}
+
+func TestBlockFileChecksum(t *testing.T) {
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	f, err := NewBlockFile(p, logger, WithCompression(BlockFileReceiptsTable, CompressionSnappy))
+	assert.Nil(t, err)
+	for i := uint64(0); i < 10; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 100)
+		assert.Nil(t, f.AppendBlock(i, types.NewHash(data).Bytes(), data, data, data, data))
+	}
+	corrupted, err := f.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, corrupted)
+	assert.Nil(t, f.Close())
+
+	// flip a bit of block 3 in the header and receipts tables
+	for _, table := range []string{BlockFileHeaderTable, BlockFileReceiptsTable} {
+		name := filepath.Join(p, fmt.Sprintf("%s.0000.rdat", table))
+		data, err := os.ReadFile(name)
+		assert.Nil(t, err)
+		data[len(data)*3/10+1] ^= 0x01
+		assert.Nil(t, os.WriteFile(name, data, 0644))
+	}
+
+	f, err = NewBlockFile(p, logger, WithCompression(BlockFileReceiptsTable, CompressionSnappy))
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.Get(BlockFileHeaderTable, 3)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	_, err = f.Get(BlockFileReceiptsTable, 3)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	header, err := f.Get(BlockFileHeaderTable, 4)
+	assert.Nil(t, err)
+	assert.EqualValues(t, bytes.Repeat([]byte{4}, 100), header)
+
+	corrupted, err = f.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string][]uint64{
+		BlockFileHeaderTable:   {3},
+		BlockFileReceiptsTable: {3},
+	}, corrupted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.Verify(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBlockTableChecksumRepair(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewWithModule("blockfile_test")
+	f, err := newTable(dir, "checksum", 50, logger)
+	assert.Nil(t, err)
+	for x := 0; x < 30; x++ {
+		assert.Nil(t, f.Append(uint64(x), getChunk(15, x)))
+	}
+	assert.Nil(t, f.Close())
+
+	// the lost checksums of the indexed items are recomputed
+	name := filepath.Join(dir, "checksum.rcrc")
+	assert.Nil(t, os.Truncate(name, checksumHeaderSize+20*checksumSize))
+	f, err = newTable(dir, "checksum", 50, logger)
+	assert.Nil(t, err)
+	assert.Nil(t, assertFileSize(name, checksumHeaderSize+30*checksumSize))
+
+	// checksums follow the head and tail truncations
+	assert.Nil(t, f.truncate(20))
+	assert.Nil(t, assertFileSize(name, checksumHeaderSize+20*checksumSize))
+	assert.Nil(t, f.truncateTail(10))
+	assert.EqualValues(t, 9, f.checksumFirst)
+	assert.Nil(t, assertFileSize(name, checksumHeaderSize+11*checksumSize))
+	for x := 9; x < 20; x++ {
+		got, err := f.Retrieve(uint64(x))
+		assert.Nil(t, err)
+		assert.EqualValues(t, getChunk(15, x), got)
+	}
+	corrupted, err := f.verify(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, corrupted)
+	assert.Nil(t, f.Close())
+
+	// tables written before the checksums are readable
+	assert.Nil(t, os.Remove(name))
+	f, err = newTable(dir, "checksum", 50, logger)
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.EqualValues(t, 20, f.checksumFirst)
+	got, err := f.Retrieve(9)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 9), got)
+	assert.Nil(t, f.Append(20, getChunk(15, 20)))
+	got, err = f.Retrieve(20)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 20), got)
+}
+
+func TestBlockFileGetRange(t *testing.T) {
+	f, err := NewBlockFile(getStoragePath(t), log.NewWithModule("blockfile_test"), WithCompression(BlockFileReceiptsTable, CompressionZstd))
+	assert.Nil(t, err)
+	defer f.Close()
+
+	tests := []struct {
+		name string
+		f    BlockFile
+	}{
+		{
+			name: "file",
+			f:    f,
+		},
+		{
+			name: "memory",
+			f:    NewMemory(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			for i := uint64(0); i < 3000; i++ {
+				data := bytes.Repeat([]byte{byte(i)}, 10)
+				assert.Nil(t, f.AppendBlock(i, types.NewHash(data).Bytes(), data, data, data, data))
+			}
+			assert.Nil(t, f.TruncateTail(10))
+
+			items, err := f.GetRange(BlockFileReceiptsTable, 10, 20, 0)
+			assert.Nil(t, err)
+			assert.Len(t, items, 10)
+			for i, item := range items {
+				assert.EqualValues(t, bytes.Repeat([]byte{byte(10 + i)}, 10), item)
+			}
+
+			// limited by bytes, but the first item is always returned
+			items, err = f.GetRange(BlockFileHeaderTable, 10, 20, 35)
+			assert.Nil(t, err)
+			assert.Len(t, items, 3)
+			items, err = f.GetRange(BlockFileHeaderTable, 10, 20, 1)
+			assert.Nil(t, err)
+			assert.Len(t, items, 1)
+
+			// clamped to the next block
+			items, err = f.GetRange(BlockFileHeaderTable, 2995, 3010, 0)
+			assert.Nil(t, err)
+			assert.Len(t, items, 5)
+
+			_, err = f.GetRange(BlockFileHeaderTable, 9, 20, 0)
+			assert.ErrorIs(t, err, ErrBlockPruned)
+			_, err = f.GetRange(BlockFileHeaderTable, 3000, 3010, 0)
+			assert.ErrorContains(t, err, "out of bounds")
+			_, err = f.GetRange(BlockFileHeaderTable, 20, 20, 0)
+			assert.Error(t, err)
+			_, err = f.GetRange("unknown", 10, 20, 0)
+			assert.ErrorContains(t, err, "unknown table")
+
+			it := f.Iterator(BlockFileTXsTable, 10, 5000)
+			expected := uint64(10)
+			for it.Next() {
+				assert.EqualValues(t, expected, it.Number())
+				assert.EqualValues(t, bytes.Repeat([]byte{byte(expected)}, 10), it.Value())
+				expected++
+			}
+			assert.Nil(t, it.Error())
+			assert.EqualValues(t, 3000, expected)
+
+			it = f.Iterator(BlockFileTXsTable, 0, 10)
+			assert.False(t, it.Next())
+			assert.ErrorIs(t, it.Error(), ErrBlockPruned)
+		})
+	}
+}
+
+func TestBlockTableRetrieveRange(t *testing.T) {
+	f, err := newTable(t.TempDir(), "range", 50, log.NewWithModule("blockfile_test"))
+	assert.Nil(t, err)
+	defer f.Close()
+	for x := 0; x < 30; x++ {
+		assert.Nil(t, f.Append(uint64(x), getChunk(15, x)))
+	}
+	assert.Nil(t, f.truncateTail(7))
+
+	// the range spans data files
+	for from := uint64(6); from < 30; from++ {
+		items, err := f.RetrieveRange(from, 30, 0)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 30-from, len(items))
+		for i, item := range items {
+			assert.EqualValues(t, getChunk(15, int(from)+i), item)
+		}
+	}
+
+	// a corrupted item fails the range
+	name := filepath.Join(f.path, "range.0004.rdat")
+	data, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	data[20] ^= 0x01
+	assert.Nil(t, os.WriteFile(name, data, 0644))
+	_, err = f.RetrieveRange(6, 30, 0)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	items, err := f.RetrieveRange(6, 7, 0)
+	assert.Nil(t, err)
+	assert.Len(t, items, 7)
+}
+
+func TestBlockTableUpgradeIndex(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewWithModule("blockfile_test")
+	f, err := newTable(dir, "upgrade", 50, logger)
+	assert.Nil(t, err)
+	for x := 0; x < 30; x++ {
+		assert.Nil(t, f.Append(uint64(x), getChunk(15, x)))
+	}
+	assert.Nil(t, f.truncateTail(7))
+	assert.Nil(t, f.Close())
+
+	// rewrite the index in the v1 format
+	data, err := os.ReadFile(indexPath(dir, "upgrade"))
+	assert.Nil(t, err)
+	assert.Nil(t, checkIndexHeader(data[:indexHeaderSize]))
+	var v1 []byte
+	for offset := indexHeaderSize; offset < len(data); offset += indexEntrySize {
+		var entry indexEntry
+		assert.Nil(t, entry.unmarshalBinary(data[offset:offset+indexEntrySize]))
+		b := make([]byte, indexEntrySizeV1)
+		binary.BigEndian.PutUint16(b[:2], uint16(entry.filenum))
+		binary.BigEndian.PutUint32(b[2:], uint32(entry.offset))
+		v1 = append(v1, b...)
+	}
+	// a torn entry is dropped
+	v1 = append(v1, 0x00, 0x01)
+	assert.Nil(t, os.Remove(indexPath(dir, "upgrade")))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "upgrade.ridx"), v1, 0644))
+	assert.True(t, tableExists(dir, "upgrade"))
+
+	f, err = newTable(dir, "upgrade", 50, logger)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = os.Stat(filepath.Join(dir, "upgrade.ridx"))
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualValues(t, 30, f.items)
+	assert.EqualValues(t, 6, f.itemOffset)
+	assert.EqualValues(t, 2, f.tailId)
+	for x := 6; x < 30; x++ {
+		got, err := f.Retrieve(uint64(x))
+		assert.Nil(t, err)
+		assert.EqualValues(t, getChunk(15, x), got)
+	}
+	assert.Nil(t, f.Append(30, getChunk(15, 30)))
+	got, err := f.Retrieve(30)
+	assert.Nil(t, err)
+	assert.EqualValues(t, getChunk(15, 30), got)
+
+	// v1 file numbers use the full 16 bits
+	var v1Entry indexEntry
+	v1Entry.unmarshalBinaryV1([]byte{0xc0, 0x01, 0x00, 0x00, 0x01, 0x00})
+	assert.Equal(t, indexEntry{filenum: 0xc001, compression: CompressionNone, offset: 0x100}, v1Entry)
+
+	// the offsets and file numbers exceed the v1 limits
+	entry := indexEntry{filenum: 1 << 20, compression: CompressionZstd, offset: 1 << 40}
+	var decoded indexEntry
+	assert.Nil(t, decoded.unmarshalBinary(entry.marshallBinary()))
+	assert.Equal(t, entry, decoded)
+}
+
+func TestBlockFileMaxFileSize(t *testing.T) {
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	f, err := NewBlockFile(p, logger, WithSchema(BlockFileHeaderTable), WithMaxFileSize(64))
+	assert.Nil(t, err)
+	for x := 0; x < 20; x++ {
+		assert.Nil(t, f.AppendRecord(uint64(x), Record{BlockFileHeaderTable: getChunk(30, x)}))
+	}
+	assert.Nil(t, f.Close())
+
+	// 2 items per file
+	_, err = os.Stat(filepath.Join(p, fmt.Sprintf("%s.0009.rdat", BlockFileHeaderTable)))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(p, fmt.Sprintf("%s.0010.rdat", BlockFileHeaderTable)))
+	assert.True(t, os.IsNotExist(err))
+
+	f, err = NewBlockFile(p, logger, WithSchema(BlockFileHeaderTable), WithMaxFileSize(64))
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.EqualValues(t, 20, f.NextBlockNumber())
+	items, err := f.GetRange(BlockFileHeaderTable, 0, 20, 0)
+	assert.Nil(t, err)
+	assert.Len(t, items, 20)
+	for x, item := range items {
+		assert.EqualValues(t, getChunk(30, x), item)
+	}
+}
+
+func TestBlockTableAppendRollback(t *testing.T) {
+	dir := t.TempDir()
+	f, err := newTable(dir, "rollback", 50, log.NewWithModule("blockfile_test"))
+	assert.Nil(t, err)
+	defer f.Close()
+	for x := 0; x < 4; x++ {
+		assert.Nil(t, f.Append(uint64(x), getChunk(15, x)))
+	}
+
+	// fail the index write after the data and the checksums are written
+	index := f.index
+	f.index, err = openBlockFileForReadOnly(index.Name())
+	assert.Nil(t, err)
+	assert.Error(t, f.BatchAppend(4, [][]byte{getChunk(15, 4), getChunk(15, 5)}))
+	assert.Nil(t, f.index.Close())
+	f.index = index
+
+	assert.EqualValues(t, 4, f.items)
+	assert.EqualValues(t, 15, f.headBytes)
+	stat, err := f.head.Stat()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 15, stat.Size())
+	stat, err = f.checksums.Stat()
+	assert.Nil(t, err)
+	assert.EqualValues(t, checksumHeaderSize+4*checksumSize, stat.Size())
+
+	assert.Nil(t, f.BatchAppend(4, [][]byte{getChunk(15, 0xEE), getChunk(15, 0xFF)}))
+	assert.Nil(t, f.Sync())
+	for x, want := range [][]byte{getChunk(15, 0), getChunk(15, 1), getChunk(15, 2), getChunk(15, 3), getChunk(15, 0xEE), getChunk(15, 0xFF)} {
+		got, err := f.Retrieve(uint64(x))
+		assert.Nil(t, err)
+		assert.EqualValues(t, want, got)
+	}
+	result, err := f.verify(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, result)
+}
+
+func TestBlockFileSyncPolicy(t *testing.T) {
+	logger := log.NewWithModule("blockfile_test")
+	t.Run("blocks", func(t *testing.T) {
+		f, err := newBlockFile(getStoragePath(t), logger, WithSyncPolicy(SyncPolicy{Blocks: 3}))
+		assert.Nil(t, err)
+		defer f.Close()
+		for x := 0; x < 2; x++ {
+			assert.Nil(t, f.AppendBlock(uint64(x), getChunk(1, x), getChunk(1, x), getChunk(1, x), getChunk(1, x), getChunk(1, x)))
+		}
+		assert.EqualValues(t, 2, f.unsynced)
+		assert.Nil(t, f.AppendBlock(2, getChunk(1, 2), getChunk(1, 2), getChunk(1, 2), getChunk(1, 2), getChunk(1, 2)))
+		assert.EqualValues(t, 0, f.unsynced)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		f, err := newBlockFile(getStoragePath(t), logger, WithSyncPolicy(SyncPolicy{Interval: 10 * time.Millisecond}))
+		assert.Nil(t, err)
+		defer f.Close()
+		assert.Nil(t, f.AppendBlock(0, getChunk(1, 0), getChunk(1, 0), getChunk(1, 0), getChunk(1, 0), getChunk(1, 0)))
+		assert.Eventually(t, func() bool {
+			f.appendLock.Lock()
+			defer f.appendLock.Unlock()
+			return f.unsynced == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		p := getStoragePath(t)
+		f, err := newBlockFile(p, logger)
+		assert.Nil(t, err)
+		assert.Nil(t, f.AppendBlock(0, getChunk(1, 0), getChunk(1, 0), getChunk(1, 0), getChunk(1, 0), getChunk(1, 0)))
+		assert.EqualValues(t, 1, f.unsynced)
+		assert.Nil(t, f.Sync())
+		assert.EqualValues(t, 0, f.unsynced)
+		assert.Nil(t, f.Close())
+		assert.Error(t, f.Sync())
+	})
+}
+
+func readDir(t *testing.T, dir string) map[string][]byte {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		files[entry.Name()] = data
+	}
+	return files
+}
+
+func writeDir(t *testing.T, dir string, files map[string][]byte) {
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	for name, data := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+}
+
+// tornWrite returns the file after writing half of the bytes changed from before to after.
+func tornWrite(before, after []byte) []byte {
+	if len(after) > len(before) {
+		return after[:len(before)+(len(after)-len(before))/2]
+	}
+	first, last := 0, len(after)
+	for first < last && before[first] == after[first] {
+		first++
+	}
+	for last > first && before[last-1] == after[last-1] {
+		last--
+	}
+	torn := append([]byte{}, before...)
+	copy(torn[first:], after[first:first+(last-first)/2])
+	return torn
+}
+
+func TestBlockFileCrashConsistency(t *testing.T) {
+	logger := log.NewWithModule("blockfile_test")
+	block := func(number, table int) []byte {
+		return getChunk(10+table, number)
+	}
+	record := func(number int) Record {
+		r := Record{}
+		for i, name := range []string{BlockFileHeaderTable, BlockFileTXsTable, BlockFileExtraTable, BlockFileReceiptsTable} {
+			r[name] = block(number, i)
+		}
+		return r
+	}
+
+	p := getStoragePath(t)
+	f, err := NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	assert.Nil(t, f.BatchAppendRecords(0, []Record{record(0), record(1), record(2)}))
+	assert.Nil(t, f.Close())
+	before := readDir(t, p)
+
+	f, err = NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	assert.Nil(t, f.BatchAppendRecords(3, []Record{record(3), record(4)}))
+	assert.Nil(t, f.Close())
+	after := readDir(t, p)
+
+	// the files written by the append, in the write order
+	var steps []string
+	for _, name := range []string{BlockFileExtraTable, BlockFileHeaderTable, BlockFileReceiptsTable, BlockFileTXsTable} {
+		steps = append(steps, fmt.Sprintf("%s.0000.rdat", name), fmt.Sprintf("%s.rcrc", name), fmt.Sprintf("%s.idx", name))
+	}
+	steps = append(steps, commitFileName)
+	for name, data := range after {
+		changed := !bytes.Equal(before[name], data)
+		assert.Equal(t, changed, containsTable(steps, name), name)
+	}
+
+	for i, step := range steps {
+		for _, torn := range []bool{false, true} {
+			files := make(map[string][]byte)
+			for name, data := range before {
+				files[name] = data
+			}
+			for _, done := range steps[:i] {
+				files[done] = after[done]
+			}
+			files[step] = after[step]
+			if torn {
+				files[step] = tornWrite(before[step], after[step])
+			}
+
+			dir := filepath.Join(t.TempDir(), "crash")
+			writeDir(t, dir, files)
+			f, err := NewBlockFile(dir, logger)
+			assert.Nil(t, err)
+
+			// the blocks are committed by a whole commit marker only
+			expected := uint64(3)
+			if step == commitFileName && !torn {
+				expected = 5
+			}
+			assert.EqualValues(t, expected, f.NextBlockNumber(), "crash at %s, torn %v", step, torn)
+			for number := 0; number < int(expected); number++ {
+				for i, name := range []string{BlockFileHeaderTable, BlockFileTXsTable, BlockFileExtraTable, BlockFileReceiptsTable} {
+					got, err := f.Get(name, uint64(number))
+					assert.Nil(t, err)
+					assert.EqualValues(t, block(number, i), got)
+				}
+			}
+			corrupted, err := f.Verify(context.Background())
+			assert.Nil(t, err)
+			assert.Empty(t, corrupted)
+
+			// the dropped blocks are appended again
+			for number := expected; number < 6; number++ {
+				assert.Nil(t, f.AppendRecord(number, record(int(number))))
+			}
+			assert.Nil(t, f.Close())
+			f, err = NewBlockFile(dir, logger)
+			assert.Nil(t, err)
+			assert.EqualValues(t, 6, f.NextBlockNumber())
+			got, err := f.Get(BlockFileTXsTable, 5)
+			assert.Nil(t, err)
+			assert.EqualValues(t, block(5, 1), got)
+			assert.Nil(t, f.Close())
+		}
+	}
+}
+
+func concurrentItem(table string, number, generation uint64) []byte {
+	return []byte(fmt.Sprintf("%s-%d-%d", table, number, generation))
+}
+
+// checkConcurrentItem checks that the item belongs to the table and the block.
+func checkConcurrentItem(t *testing.T, table string, number uint64, item []byte) {
+	var generation uint64
+	_, err := fmt.Sscanf(string(item), table+"-%d-%d", new(uint64), &generation)
+	assert.Nil(t, err)
+	assert.EqualValues(t, concurrentItem(table, number, generation), item)
+}
+
+// testConcurrentReaders appends to f and truncates it while reading it from r, which may be f.
+func testConcurrentReaders(t *testing.T, f, r BlockFile, truncate bool) {
+	tables := []string{BlockFileHeaderTable, BlockFileTXsTable, BlockFileExtraTable, BlockFileReceiptsTable}
+	var (
+		done    = make(chan struct{})
+		readers = make(chan struct{}, 4)
+	)
+	for i := 0; i < cap(readers); i++ {
+		go func() {
+			defer func() { readers <- struct{}{} }()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				next := r.NextBlockNumber()
+				if next == 0 {
+					continue
+				}
+				number := uint64(rand.Int63n(int64(next)))
+				for _, table := range tables {
+					item, err := r.Get(table, number)
+					if err != nil {
+						// the block can only be truncated since NextBlockNumber was read
+						assert.True(t, truncate, "block %d of table %s: %v", number, table, err)
+						continue
+					}
+					checkConcurrentItem(t, table, number, item)
+				}
+				items, err := r.GetRange(BlockFileTXsTable, number, next, 0)
+				if err != nil {
+					assert.True(t, truncate, "range from block %d: %v", number, err)
+					continue
+				}
+				for i, item := range items {
+					checkConcurrentItem(t, BlockFileTXsTable, number+uint64(i), item)
+				}
+			}
+		}()
+	}
+
+	var generation uint64
+	for i := 0; i < 200; i++ {
+		next := f.NextBlockNumber()
+		if truncate && i%5 == 4 && next > 0 {
+			assert.Nil(t, f.TruncateBlocks(uint64(rand.Int63n(int64(next)))))
+			generation++
+			continue
+		}
+		count := 1 + rand.Intn(4)
+		lists := make([][][]byte, len(tables))
+		for j, table := range tables {
+			for number := next; number < next+uint64(count); number++ {
+				lists[j] = append(lists[j], concurrentItem(table, number, generation))
+			}
+		}
+		assert.Nil(t, f.BatchAppendBlock(next, lists[0], lists[0], lists[2], lists[3], lists[1]))
+	}
+	close(done)
+	for i := 0; i < cap(readers); i++ {
+		<-readers
+	}
+}
+
+func TestBlockFileConcurrentReaders(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("file truncate %v", truncate), func(t *testing.T) {
+			f, err := NewBlockFile(getStoragePath(t), log.NewWithModule("blockfile_test"), WithMaxFileSize(256))
+			assert.Nil(t, err)
+			defer f.Close()
+			testConcurrentReaders(t, f, f, truncate)
+		})
+		t.Run(fmt.Sprintf("memory truncate %v", truncate), func(t *testing.T) {
+			m := NewMemory()
+			testConcurrentReaders(t, m, m, truncate)
+		})
+	}
+}
+
+func TestBlockFileReadOnly(t *testing.T) {
+	logger := log.NewWithModule("blockfile_test")
+	dir := getStoragePath(t)
+	_, err := OpenReadOnly(dir, logger)
+	assert.NotNil(t, err)
+
+	f, err := NewBlockFile(dir, logger, WithMaxFileSize(64))
+	assert.Nil(t, err)
+	appendBlocks := func(from, to uint64) {
+		for number := from; number < to; number++ {
+			chunk := getChunk(20, int(number))
+			assert.Nil(t, f.AppendBlock(number, chunk, chunk, chunk, chunk, chunk))
+		}
+	}
+	appendBlocks(0, 10)
+
+	r, err := OpenReadOnly(dir, logger)
+	assert.Nil(t, err)
+	_, err = NewBlockFile(dir, logger)
+	assert.NotNil(t, err, "the writer is already open")
+	r2, err := OpenReadOnly(dir, logger)
+	assert.Nil(t, err, "the readers share the lock")
+	assert.Nil(t, r2.Close())
+	assert.EqualValues(t, 10, r.NextBlockNumber())
+	item, err := r.Get(BlockFileHeaderTable, 9)
+	assert.Nil(t, err)
+	assert.Equal(t, getChunk(20, 9), item)
+	_, err = r.Get(BlockFileHeaderTable, 10)
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, r.AppendBlock(10, nil, nil, nil, nil, nil), ErrReadOnly)
+	assert.ErrorIs(t, r.TruncateBlocks(0), ErrReadOnly)
+	assert.ErrorIs(t, r.TruncateTail(5), ErrReadOnly)
+
+	// the appended blocks become visible
+	appendBlocks(10, 15)
+	assert.EqualValues(t, 15, r.NextBlockNumber())
+	items, err := r.GetRange(BlockFileTXsTable, 8, 20, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(items))
+	assert.Equal(t, getChunk(20, 14), items[6])
+
+	// the index replaced by the tail truncation is reloaded
+	assert.Nil(t, f.TruncateTail(8))
+	appendBlocks(15, 17)
+	item, err = r.Get(BlockFileExtraTable, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, getChunk(20, 16), item)
+	_, err = r.Get(BlockFileExtraTable, 0)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	// the truncations wait for the reads in progress
+	readersLock := r.(*readOnlyBlockFile).readersLock
+	assert.Nil(t, readersLock.rlock())
+	truncated := make(chan error)
+	go func() {
+		truncated <- f.TruncateBlocks(11)
+	}()
+	select {
+	case <-truncated:
+		t.Fatal("truncation should wait for the readers")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.EqualValues(t, 17, r.NextBlockNumber())
+	assert.Nil(t, readersLock.runlock())
+	assert.Nil(t, <-truncated)
+
+	// the truncated blocks stop being visible
+	assert.EqualValues(t, 12, r.NextBlockNumber())
+	_, err = r.Get(BlockFileHeaderTable, 12)
+	assert.NotNil(t, err)
+	appendBlocks(12, 13)
+	item, err = r.Get(BlockFileHeaderTable, 12)
+	assert.Nil(t, err)
+	assert.Equal(t, getChunk(20, 12), item)
+	corrupted, err := r.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, corrupted)
+
+	// the writer restarts while the readers are open
+	assert.Nil(t, f.Close())
+	f, err = NewBlockFile(dir, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 13, f.NextBlockNumber())
+	appendBlocks(13, 14)
+	assert.EqualValues(t, 14, r.NextBlockNumber())
+	item, err = r.Get(BlockFileHeaderTable, 13)
+	assert.Nil(t, err)
+	assert.Equal(t, getChunk(20, 13), item)
+	assert.Nil(t, r.Close())
+	assert.Nil(t, f.Close())
+
+	// the lock file is created if the writer never did
+	assert.Nil(t, os.Remove(filepath.Join(dir, readLockFileName)))
+	r, err = OpenReadOnly(dir, logger)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 14, r.NextBlockNumber())
+	assert.Nil(t, r.Close())
+}
+
+func TestBlockFileReadOnlyConcurrentReaders(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("truncate %v", truncate), func(t *testing.T) {
+			dir := getStoragePath(t)
+			f, err := NewBlockFile(dir, log.NewWithModule("blockfile_test"), WithMaxFileSize(256))
+			assert.Nil(t, err)
+			defer f.Close()
+			r, err := OpenReadOnly(dir, log.NewWithModule("blockfile_test"))
+			assert.Nil(t, err)
+			defer r.Close()
+			testConcurrentReaders(t, f, r, truncate)
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	f, err := NewBlockFile(p, logger, WithSchema(BlockFileHeaderTable, BlockFileTXsTable), WithMaxFileSize(50))
+	assert.Nil(t, err)
+	for x := 0; x < 10; x++ {
+		assert.Nil(t, f.AppendRecord(uint64(x), Record{BlockFileHeaderTable: getChunk(20, x), BlockFileTXsTable: getChunk(10, x)}))
+	}
+	assert.Nil(t, f.TruncateTail(4))
+	assert.Nil(t, f.Close())
+
+	info, err := Inspect(p)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 10, info.Committed)
+	assert.EqualValues(t, 4, info.Tail)
+	assert.Len(t, info.Tables, 2)
+	header := info.Tables[0]
+	assert.Equal(t, BlockFileHeaderTable, header.Name)
+	assert.EqualValues(t, 10, header.Items)
+	assert.EqualValues(t, 4, header.Tail)
+	assert.EqualValues(t, 2, header.TailFile)
+	assert.EqualValues(t, 4, header.HeadFile)
+	assert.EqualValues(t, map[uint32]int64{2: 40, 3: 40, 4: 40}, header.DataFiles)
+	assert.EqualValues(t, 6, header.Checksums)
+	assert.Empty(t, header.Problems)
+	assert.Empty(t, info.Tables[1].Problems)
+
+	// a torn head file and a leftover data file
+	name := filepath.Join(p, fmt.Sprintf("%s.0004.rdat", BlockFileHeaderTable))
+	assert.Nil(t, os.Truncate(name, 30))
+	assert.Nil(t, os.WriteFile(filepath.Join(p, fmt.Sprintf("%s.0009.rdat", BlockFileHeaderTable)), nil, 0644))
+	info, err = Inspect(p)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"item 9 ends at 40 beyond data file 4 of size 30",
+		"data file 9 out of [2, 4]",
+	}, info.Tables[0].Problems)
+	assert.Empty(t, info.Tables[0].Uncommitted)
+
+	// the data appended past the committed blocks is not a problem
+	head := info.Tables[1].HeadFile
+	name = filepath.Join(p, fmt.Sprintf("%s.%04d.rdat", BlockFileTXsTable, head))
+	data, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = data.Write(getChunk(10, 10))
+	assert.Nil(t, err)
+	assert.Nil(t, data.Close())
+	info, err = Inspect(p)
+	assert.Nil(t, err)
+	assert.Empty(t, info.Tables[1].Problems)
+	assert.Equal(t, []string{fmt.Sprintf("10 dangling bytes in data file %d", head)}, info.Tables[1].Uncommitted)
+}
+
+func TestIndexedBlockFile(t *testing.T) {
+	signer, err := types.GenerateSigner()
+	assert.Nil(t, err)
+	var nonce uint64
+	block := func(number uint64) (*types.Hash, []byte, []byte, []*types.Transaction) {
+		header := &types.BlockHeader{Number: number, TotalGasFee: big.NewInt(0), GasFeeReward: big.NewInt(0)}
+		headerData, err := header.Marshal()
+		assert.Nil(t, err)
+		var txs []*types.Transaction
+		for i := 0; i < 2; i++ {
+			tx, err := types.GenerateTransactionWithGasPrice(nonce, 21000, big.NewInt(1), signer)
+			assert.Nil(t, err)
+			txs = append(txs, tx)
+			nonce++
+		}
+		txsData, err := types.MarshalTransactions(txs)
+		assert.Nil(t, err)
+		return header.Hash(), headerData, txsData, txs
+	}
+	type indexedBlock struct {
+		hash *types.Hash
+		txs  []*types.Transaction
+	}
+	checkIndexed := func(t *testing.T, ib IndexedBlockFile, number uint64, b indexedBlock) {
+		got, err := ib.GetBlockNumber(b.hash)
+		assert.Nil(t, err)
+		assert.EqualValues(t, number, got)
+		for i, tx := range b.txs {
+			meta, err := ib.GetTransactionMeta(tx.GetHash())
+			assert.Nil(t, err)
+			assert.Equal(t, b.hash.String(), meta.BlockHash.String())
+			assert.EqualValues(t, number, meta.BlockHeight)
+			assert.EqualValues(t, i, meta.Index)
+		}
+	}
+	checkMissing := func(t *testing.T, ib IndexedBlockFile, b indexedBlock) {
+		_, err := ib.GetBlockNumber(b.hash)
+		assert.ErrorIs(t, err, kv.ErrorNotFound)
+		for _, tx := range b.txs {
+			_, err := ib.GetTransactionMeta(tx.GetHash())
+			assert.ErrorIs(t, err, kv.ErrorNotFound)
+		}
+	}
+
+	p := getStoragePath(t)
+	logger := log.NewWithModule("blockfile_test")
+	bf, err := NewBlockFile(p, logger)
+	assert.Nil(t, err)
+	db := kv.NewMemory()
+	ib, err := NewIndexed(bf, db, WithIndexPrefix([]byte("index-")))
+	assert.Nil(t, err)
+
+	var blocks []indexedBlock
+	for number := uint64(0); number < 4; number++ {
+		hash, header, txs, list := block(number)
+		if number%2 == 0 {
+			assert.Nil(t, ib.AppendBlock(number, hash.Bytes(), header, nil, nil, txs))
+		} else {
+			// the hash is computed from the header
+			assert.Nil(t, ib.AppendRecord(number, Record{BlockFileHeaderTable: header, BlockFileTXsTable: txs, BlockFileExtraTable: nil, BlockFileReceiptsTable: nil}))
+		}
+		blocks = append(blocks, indexedBlock{hash: hash, txs: list})
+	}
+	for number, b := range blocks {
+		checkIndexed(t, ib, uint64(number), b)
+	}
+
+	// the blocks failing to index are not appended
+	_, header, _, _ := block(4)
+	assert.Error(t, ib.AppendBlock(4, nil, header, nil, nil, []byte("invalid")))
+	assert.EqualValues(t, 4, ib.NextBlockNumber())
+
+	// truncating nothing keeps the index
+	assert.Nil(t, ib.TruncateBlocks(3))
+	assert.Nil(t, ib.TruncateBlocks(math.MaxUint64))
+	for number, b := range blocks {
+		checkIndexed(t, ib, uint64(number), b)
+	}
+
+	assert.Nil(t, ib.TruncateBlocks(1))
+	checkIndexed(t, ib, 1, blocks[1])
+	checkMissing(t, ib, blocks[2])
+	checkMissing(t, ib, blocks[3])
+
+	// blocks appended without index update are indexed on open
+	hash, header, txs, list := block(2)
+	blocks[2] = indexedBlock{hash: hash, txs: list}
+	assert.Nil(t, bf.AppendBlock(2, hash.Bytes(), header, nil, nil, txs))
+	ib, err = NewIndexed(bf, db, WithIndexPrefix([]byte("index-")))
+	assert.Nil(t, err)
+	checkIndexed(t, ib, 2, blocks[2])
+
+	// blocks truncated without index update are dropped on open
+	assert.Nil(t, bf.TruncateBlocks(1))
+	ib, err = NewIndexed(bf, db, WithIndexPrefix([]byte("index-")))
+	assert.Nil(t, err)
+	checkMissing(t, ib, blocks[2])
+	checkIndexed(t, ib, 1, blocks[1])
+
+	// a new index starts from the tail
+	assert.Nil(t, ib.TruncateTail(1))
+	ib, err = NewIndexed(bf, kv.NewMemory())
+	assert.Nil(t, err)
+	checkMissing(t, ib, blocks[0])
+	checkIndexed(t, ib, 1, blocks[1])
+	assert.Nil(t, ib.Close())
+}
@@ -0,0 +1,64 @@
+package blockfile
+
+import (
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression is the compression algorithm of a table item, it is flagged in the index entry of the item.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// WithCompression compresses the items appended to the given table. The items written before,
+// or not worth compressing, are kept raw, so the compression of a table may be changed at any time.
+func WithCompression(table string, compression Compression) Option {
+	return func(c *config) {
+		if c.compression == nil {
+			c.compression = make(map[string]Compression)
+		}
+		c.compression[table] = compression
+	}
+}
+
+// compress returns the compressed blob, or the raw one if compression doesn't help.
+func compress(compression Compression, blob []byte) ([]byte, Compression) {
+	var compressed []byte
+	switch compression {
+	case CompressionSnappy:
+		compressed = snappy.Encode(nil, blob)
+	case CompressionZstd:
+		compressed = zstdEncoder.EncodeAll(blob, make([]byte, 0, len(blob)))
+	default:
+		return blob, CompressionNone
+	}
+	if len(compressed) >= len(blob) {
+		return blob, CompressionNone
+	}
+	return compressed, compression
+}
+
+func decompress(compression Compression, blob []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return blob, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, blob)
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(blob, nil)
+	default:
+		return nil, errors.Wrapf(ErrUnknownCompression, "compression %d", compression)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package blockfile
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/fileutil"
+)
+
+// fileLock is the exclusive lock held by the writer while it is open.
+type fileLock struct {
+	releaser fileutil.Releaser
+}
+
+func lockFile(name string) (*fileLock, error) {
+	releaser, _, err := fileutil.Flock(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{releaser: releaser}, nil
+}
+
+func (l *fileLock) release() error {
+	return l.releaser.Release()
+}
+
+// rwFileLock only supports the writer, there is no read-only opener on this platform.
+type rwFileLock struct{}
+
+func openRWFileLock(name string) (*rwFileLock, error) {
+	return &rwFileLock{}, nil
+}
+
+func (l *rwFileLock) lock() error {
+	return nil
+}
+
+func (l *rwFileLock) unlock() error {
+	return nil
+}
+
+func (l *rwFileLock) rlock() error {
+	return errors.New("read-only block file is not supported on this platform")
+}
+
+func (l *rwFileLock) runlock() error {
+	return nil
+}
+
+func (l *rwFileLock) close() error {
+	return nil
+}
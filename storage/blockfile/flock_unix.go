@@ -0,0 +1,89 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package blockfile
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// fileLock is the exclusive flock on the FLOCK file held by the writer while it is open.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile locks the file exclusively without blocking.
+func lockFile(name string) (*fileLock, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) release() error {
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		return err
+	}
+	return l.f.Close()
+}
+
+// rwFileLock is the flock on the RLOCK file, shared by the read-only openers while they
+// read and held exclusively by the writer while it repairs, truncates or removes files.
+// The flock belongs to the open file, so the shared lock is counted per process.
+type rwFileLock struct {
+	f           *os.File
+	readersLock sync.Mutex // guards readers
+	readers     int
+}
+
+// openRWFileLock opens the lock file, creating it if it is missing.
+func openRWFileLock(name string) (*rwFileLock, error) {
+	f, err := os.OpenFile(name, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &rwFileLock{f: f}, nil
+}
+
+// lock blocks until the readers of every process are done.
+func (l *rwFileLock) lock() error {
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_EX)
+}
+
+func (l *rwFileLock) unlock() error {
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
+
+// rlock blocks while the writer holds the lock exclusively.
+func (l *rwFileLock) rlock() error {
+	l.readersLock.Lock()
+	defer l.readersLock.Unlock()
+	if l.readers == 0 {
+		if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_SH); err != nil {
+			return err
+		}
+	}
+	l.readers++
+	return nil
+}
+
+func (l *rwFileLock) runlock() error {
+	l.readersLock.Lock()
+	defer l.readersLock.Unlock()
+	l.readers--
+	if l.readers == 0 {
+		return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	}
+	return nil
+}
+
+// close closes the lock file, which releases the lock.
+func (l *rwFileLock) close() error {
+	return l.f.Close()
+}
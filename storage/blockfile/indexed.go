@@ -0,0 +1,302 @@
+package blockfile
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"github.com/axiomesh/axiom-kit/storage/kv"
+	"github.com/axiomesh/axiom-kit/types"
+)
+
+// The index keys under the prefix:
+//
+//	h + block hash -> block number(8)
+//	t + tx hash    -> types.TransactionMeta
+//	n + number(8)  -> block hash | tx hashes, to roll back the block
+//	i              -> next indexed block number(8)
+const (
+	indexBlockHashPrefix = 'h'
+	indexTxHashPrefix    = 't'
+	indexNumberPrefix    = 'n'
+	indexHeightKey       = 'i'
+)
+
+// IndexedBlockFile is a BlockFile looking up the blocks and the transactions by hash.
+// The index is not updated in the same step as the block file: the lookups miss the blocks
+// being appended until their index is committed, right after the append returns.
+type IndexedBlockFile interface {
+	BlockFile
+
+	// GetBlockNumber returns the number of the block with the given hash.
+	GetBlockNumber(hash *types.Hash) (uint64, error)
+
+	// GetTransactionMeta returns the block and the position of the transaction with the given hash.
+	GetTransactionMeta(txHash *types.Hash) (*types.TransactionMeta, error)
+}
+
+type IndexOption func(ib *indexedBlockFile)
+
+// WithIndexPrefix prefixes the index keys, so that the index shares db with other data.
+func WithIndexPrefix(prefix []byte) IndexOption {
+	return func(ib *indexedBlockFile) {
+		ib.prefix = append([]byte{}, prefix...)
+	}
+}
+
+type indexedBlockFile struct {
+	BlockFile
+	db     kv.Storage
+	prefix []byte
+
+	// lock serializes the appends and truncations with their index updates
+	lock sync.Mutex
+}
+
+// NewIndexed indexes the blocks of bf by hash, and their transactions by hash, in db.
+// The index is written after every append and rolled back before every truncation, the
+// two are not atomic, so a crash in between leaves the appended blocks unindexed or the
+// kept blocks rolled back until the index is caught up with bf by NewIndexed on open. The block hashes are
+// read from the hash table if the schema has it, or computed from the headers otherwise,
+// so the hashes passed to AppendBlock must be the header hashes.
+// The pruned blocks stay indexed. Close closes bf but not db.
+func NewIndexed(bf BlockFile, db kv.Storage, opts ...IndexOption) (IndexedBlockFile, error) {
+	ib := &indexedBlockFile{
+		BlockFile: bf,
+		db:        db,
+	}
+	for _, opt := range opts {
+		opt(ib)
+	}
+	if err := ib.recover(); err != nil {
+		return nil, err
+	}
+	return ib, nil
+}
+
+func (ib *indexedBlockFile) key(prefix byte, suffix []byte) []byte {
+	key := make([]byte, 0, len(ib.prefix)+1+len(suffix))
+	key = append(key, ib.prefix...)
+	key = append(key, prefix)
+	return append(key, suffix...)
+}
+
+func (ib *indexedBlockFile) numberKey(number uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], number)
+	return ib.key(indexNumberPrefix, b[:])
+}
+
+// indexed returns the next indexed block number.
+func (ib *indexedBlockFile) indexed() uint64 {
+	data := ib.db.Get(ib.key(indexHeightKey, nil))
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+func (ib *indexedBlockFile) putIndexed(batch kv.Batch, next uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], next)
+	batch.Put(ib.key(indexHeightKey, nil), b[:])
+}
+
+// recover rolls back the index of the blocks missing from the block file, and indexes
+// the blocks appended since the last index update, one batch per block.
+func (ib *indexedBlockFile) recover() error {
+	next, indexed := ib.NextBlockNumber(), ib.indexed()
+	if indexed > next {
+		return ib.rollback(next, indexed)
+	}
+	if indexed < next {
+		from := indexed
+		if has := ib.db.Has(ib.key(indexHeightKey, nil)); !has {
+			// a new index of an existing block file starts from its tail
+			from = uint64(sort.Search(int(next), func(i int) bool {
+				_, err := ib.Get(BlockFileHeaderTable, uint64(i))
+				return !errors.Is(err, ErrBlockPruned)
+			}))
+		}
+		for number := from; number < next; number++ {
+			// the hash table is optional
+			hash, _ := ib.Get(BlockFileHashTable, number)
+			header, err := ib.Get(BlockFileHeaderTable, number)
+			if err != nil {
+				return err
+			}
+			txs, err := ib.Get(BlockFileTXsTable, number)
+			if err != nil {
+				return err
+			}
+			batch, err := ib.prepareIndex(number, [][]byte{hash}, [][]byte{header}, [][]byte{txs})
+			if err != nil {
+				return err
+			}
+			batch.Commit()
+		}
+	}
+	return nil
+}
+
+// prepareIndex returns the batch indexing the blocks from number, the hashes missing
+// from listOfHash are computed from the headers.
+func (ib *indexedBlockFile) prepareIndex(number uint64, listOfHash, listOfHeader, listOfTxs [][]byte) (kv.Batch, error) {
+	batch := ib.db.NewBatch()
+	for i := range listOfHeader {
+		height := number + uint64(i)
+		var hash *types.Hash
+		if len(listOfHash[i]) == common.HashLength {
+			hash = types.NewHash(listOfHash[i])
+		} else {
+			header := &types.BlockHeader{}
+			if err := header.Unmarshal(listOfHeader[i]); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode header of block %d", height)
+			}
+			hash = header.Hash()
+		}
+		txs, err := types.UnmarshalTransactions(listOfTxs[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode transactions of block %d", height)
+		}
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], height)
+		batch.Put(ib.key(indexBlockHashPrefix, hash.Bytes()), b[:])
+
+		rollback := make([]byte, 0, (1+len(txs))*common.HashLength)
+		rollback = append(rollback, hash.Bytes()...)
+		for j, tx := range txs {
+			meta := &types.TransactionMeta{
+				BlockHash:   hash,
+				BlockHeight: height,
+				Index:       uint64(j),
+			}
+			data, err := meta.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			txHash := tx.GetHash().Bytes()
+			batch.Put(ib.key(indexTxHashPrefix, txHash), data)
+			rollback = append(rollback, txHash...)
+		}
+		batch.Put(ib.numberKey(height), rollback)
+	}
+	ib.putIndexed(batch, number+uint64(len(listOfHeader)))
+	return batch, nil
+}
+
+// rollback removes the index of the blocks in [from, to) in a single batch.
+func (ib *indexedBlockFile) rollback(from, to uint64) error {
+	batch := ib.db.NewBatch()
+	for number := from; number < to; number++ {
+		rollback := ib.db.Get(ib.numberKey(number))
+		if len(rollback)%common.HashLength != 0 {
+			return errors.Errorf("corrupted index of block %d", number)
+		}
+		for offset := 0; offset < len(rollback); offset += common.HashLength {
+			prefix := byte(indexTxHashPrefix)
+			if offset == 0 {
+				prefix = indexBlockHashPrefix
+			}
+			batch.Delete(ib.key(prefix, rollback[offset:offset+common.HashLength]))
+		}
+		batch.Delete(ib.numberKey(number))
+	}
+	ib.putIndexed(batch, from)
+	batch.Commit()
+	return nil
+}
+
+func (ib *indexedBlockFile) GetBlockNumber(hash *types.Hash) (uint64, error) {
+	data := ib.db.Get(ib.key(indexBlockHashPrefix, hash.Bytes()))
+	if len(data) != 8 {
+		return 0, errors.Wrapf(kv.ErrorNotFound, "block %s", hash)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func (ib *indexedBlockFile) GetTransactionMeta(txHash *types.Hash) (*types.TransactionMeta, error) {
+	data := ib.db.Get(ib.key(indexTxHashPrefix, txHash.Bytes()))
+	if data == nil {
+		return nil, errors.Wrapf(kv.ErrorNotFound, "transaction %s", txHash)
+	}
+	meta := &types.TransactionMeta{}
+	if err := meta.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (ib *indexedBlockFile) AppendBlock(number uint64, hash, header, extra, receipts, transactions []byte) error {
+	return ib.BatchAppendBlock(number, [][]byte{hash}, [][]byte{header}, [][]byte{extra}, [][]byte{receipts}, [][]byte{transactions})
+}
+
+func (ib *indexedBlockFile) BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) error {
+	ib.lock.Lock()
+	defer ib.lock.Unlock()
+
+	if len(listOfHash) != len(listOfHeader) || len(listOfTransactions) != len(listOfHeader) {
+		return errors.New("doBatch append block data param's length not match")
+	}
+	// the index is prepared first, so that nothing fails after the append
+	batch, err := ib.prepareIndex(number, listOfHash, listOfHeader, listOfTransactions)
+	if err != nil {
+		return err
+	}
+	if err := ib.BlockFile.BatchAppendBlock(number, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions); err != nil {
+		return err
+	}
+	// a crash before the commit is caught up by recover on open
+	batch.Commit()
+	return nil
+}
+
+func (ib *indexedBlockFile) AppendRecord(number uint64, record Record) error {
+	return ib.BatchAppendRecords(number, []Record{record})
+}
+
+func (ib *indexedBlockFile) BatchAppendRecords(number uint64, records []Record) error {
+	ib.lock.Lock()
+	defer ib.lock.Unlock()
+
+	listOfHash := make([][]byte, len(records))
+	listOfHeader := make([][]byte, len(records))
+	listOfTxs := make([][]byte, len(records))
+	for i, record := range records {
+		listOfHash[i], listOfHeader[i], listOfTxs[i] = record[BlockFileHashTable], record[BlockFileHeaderTable], record[BlockFileTXsTable]
+	}
+	batch, err := ib.prepareIndex(number, listOfHash, listOfHeader, listOfTxs)
+	if err != nil {
+		return err
+	}
+	if err := ib.BlockFile.BatchAppendRecords(number, records); err != nil {
+		return err
+	}
+	// a crash before the commit is caught up by recover on open
+	batch.Commit()
+	return nil
+}
+
+func (ib *indexedBlockFile) TruncateBlocks(targetBlock uint64) error {
+	ib.lock.Lock()
+	defer ib.lock.Unlock()
+
+	next := ib.NextBlockNumber()
+	if targetBlock >= next {
+		return nil
+	}
+	if err := ib.rollback(targetBlock+1, next); err != nil {
+		return err
+	}
+	if err := ib.BlockFile.TruncateBlocks(targetBlock); err != nil {
+		// index the blocks kept by the failed truncation again
+		if rerr := ib.recover(); rerr != nil {
+			return errors.Wrapf(err, "failed to recover index: %v", rerr)
+		}
+		return err
+	}
+	return nil
+}
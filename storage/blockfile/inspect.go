@@ -0,0 +1,246 @@
+package blockfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info describes the files of a block file.
+type Info struct {
+	// Committed is the number of the committed blocks, math.MaxUint64 without commit marker.
+	Committed uint64
+
+	// Tail is the first kept block.
+	Tail uint64
+
+	Tables []TableInfo
+}
+
+// TableInfo describes the files of a table.
+type TableInfo struct {
+	Name         string
+	IndexVersion int
+	IndexSize    int64
+
+	// Items is the number of the indexed items, the pruned ones included.
+	Items uint64
+
+	// Tail is the number of the pruned items.
+	Tail uint64
+
+	TailFile uint32
+	HeadFile uint32
+
+	// DataFiles holds the size of the data files by number.
+	DataFiles map[uint32]int64
+
+	// Checksums is the number of the checksummed items.
+	Checksums uint64
+
+	// Problems lists the inconsistencies between the index and the data files,
+	// the ones past the last consistent item are repaired on open.
+	Problems []string
+
+	// Uncommitted lists the inconsistencies of the items past the committed blocks, which
+	// are the appends in progress of a running writer, or are dropped on open otherwise.
+	Uncommitted []string
+}
+
+// Inspect reads the files of the block file under p without opening it, so it works on
+// the block files failing to open or opened by another process. Nothing is written.
+func Inspect(p string) (*Info, error) {
+	info := &Info{Committed: math.MaxUint64}
+	// the marker is read first, so that the files listed hold the committed items
+	// of a running writer
+	if data, err := os.ReadFile(filepath.Join(p, commitFileName)); err == nil {
+		var seq uint64
+		for slot := 0; slot+commitSlotSize <= len(data) && slot < 2*commitSlotSize; slot += commitSlotSize {
+			s, number, ok := decodeCommitSlot(data[slot : slot+commitSlotSize])
+			if ok && (info.Committed == math.MaxUint64 || s > seq) {
+				seq, info.Committed = s, number
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, "."+indexFileExtension):
+			names = append(names, strings.TrimSuffix(name, "."+indexFileExtension))
+		case strings.HasSuffix(name, "."+indexFileExtensionV1):
+			table := strings.TrimSuffix(name, "."+indexFileExtensionV1)
+			if !containsTable(names, table) {
+				names = append(names, table)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	if data, err := os.ReadFile(filepath.Join(p, tailFileName)); err == nil && len(data) == 8 {
+		info.Tail = binary.BigEndian.Uint64(data)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, name := range names {
+		table, err := inspectTable(p, name, entries, info.Committed)
+		if err != nil {
+			return nil, err
+		}
+		info.Tables = append(info.Tables, *table)
+	}
+	return info, nil
+}
+
+func inspectTable(p, name string, entries []os.DirEntry, committed uint64) (*TableInfo, error) {
+	table := &TableInfo{
+		Name:         name,
+		IndexVersion: indexVersion,
+		DataFiles:    make(map[uint32]int64),
+	}
+	// problem records a problem of the item, the uncommitted items are known by the marker only
+	problem := func(item uint64, format string, args ...interface{}) {
+		if committed != math.MaxUint64 && item >= committed {
+			table.Uncommitted = append(table.Uncommitted, fmt.Sprintf(format, args...))
+			return
+		}
+		table.Problems = append(table.Problems, fmt.Sprintf(format, args...))
+	}
+	for _, entry := range entries {
+		parts := strings.Split(entry.Name(), ".")
+		if len(parts) != 3 || parts[0] != name || parts[2] != "rdat" {
+			continue
+		}
+		num, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		table.DataFiles[uint32(num)] = fi.Size()
+	}
+
+	path, entrySize := indexPath(p, name), int64(indexEntrySize)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path, entrySize = filepath.Join(p, fmt.Sprintf("%s.%s", name, indexFileExtensionV1)), indexEntrySizeV1
+		table.IndexVersion = 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	table.IndexSize = stat.Size()
+
+	r := bufio.NewReader(f)
+	if table.IndexVersion != 1 {
+		header := make([]byte, indexHeaderSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			table.Problems = append(table.Problems, "torn index header")
+			return table, nil
+		}
+		if err := checkIndexHeader(header); err != nil {
+			table.Problems = append(table.Problems, err.Error())
+			return table, nil
+		}
+	}
+
+	var (
+		buffer = make([]byte, entrySize)
+		last   indexEntry
+		count  uint64
+	)
+	for ; ; count++ {
+		if _, err := io.ReadFull(r, buffer); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			if count == 0 {
+				table.Problems = append(table.Problems, "torn index entry")
+			} else {
+				problem(table.Tail+count-1, "torn index entry")
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var entry indexEntry
+		if table.IndexVersion == 1 {
+			entry.unmarshalBinaryV1(buffer)
+		} else if err := entry.unmarshalBinary(buffer); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			table.TailFile, table.HeadFile, table.Tail = entry.filenum, entry.filenum, entry.offset
+			last = indexEntry{filenum: entry.filenum}
+			continue
+		}
+		item := table.Tail + count - 1
+		switch {
+		case entry.filenum < last.filenum:
+			problem(item, "item %d in data file %d before data file %d", item, entry.filenum, last.filenum)
+		case entry.filenum == last.filenum && entry.offset < last.offset:
+			problem(item, "item %d ends at %d before its start %d", item, entry.offset, last.offset)
+		case entry.compression > CompressionZstd:
+			problem(item, "item %d has unknown compression %d", item, entry.compression)
+		}
+		if size, ok := table.DataFiles[entry.filenum]; !ok {
+			problem(item, "item %d in missing data file %d", item, entry.filenum)
+		} else if int64(entry.offset) > size {
+			problem(item, "item %d ends at %d beyond data file %d of size %d", item, entry.offset, entry.filenum, size)
+		}
+		last = entry
+		table.HeadFile = entry.filenum
+	}
+	if count > 0 {
+		table.Items = table.Tail + count - 1
+		if size, ok := table.DataFiles[last.filenum]; ok && size > int64(last.offset) {
+			problem(table.Items, "%d dangling bytes in data file %d", size-int64(last.offset), last.filenum)
+		}
+	}
+	var nums []int
+	for num := range table.DataFiles {
+		nums = append(nums, int(num))
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		// the next head file is created before the first item is indexed in it
+		if uint32(num) < table.TailFile || uint32(num) > table.HeadFile+1 {
+			table.Problems = append(table.Problems, fmt.Sprintf("data file %d out of [%d, %d]", num, table.TailFile, table.HeadFile))
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(p, fmt.Sprintf("%s.rcrc", name))); err == nil && len(data) >= checksumHeaderSize {
+		first := binary.BigEndian.Uint64(data[:checksumHeaderSize])
+		table.Checksums = uint64(len(data)-checksumHeaderSize) / checksumSize
+		if end := first + table.Checksums; end != table.Items {
+			// the items below both ends are indexed and checksummed
+			consistent := end
+			if table.Items < consistent {
+				consistent = table.Items
+			}
+			problem(consistent, "checksums of items [%d, %d) for %d items", first, end, table.Items)
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,84 @@
+package blockfile
+
+const (
+	iteratorBatchItems = 1024
+	iteratorBatchBytes = 4 * 1024 * 1024
+)
+
+// Iterator iterates the items of a table in block number order.
+type Iterator interface {
+	// Next moves to the next item, it returns false once the iteration is done or failed.
+	Next() bool
+
+	// Number returns the block number of the current item.
+	Number() uint64
+
+	// Value returns the current item.
+	Value() []byte
+
+	// Error returns the error which stopped the iteration, if any.
+	Error() error
+}
+
+// rangeIterator iterates the items in [next, to) by batches of GetRange,
+// to is clamped to the next block number on creation.
+type rangeIterator struct {
+	bf   BlockFile
+	kind string
+	next uint64
+	to   uint64
+
+	batch  [][]byte
+	number uint64
+	value  []byte
+	err    error
+}
+
+func newRangeIterator(bf BlockFile, kind string, from, to uint64) Iterator {
+	if next := bf.NextBlockNumber(); to > next {
+		to = next
+	}
+	return &rangeIterator{
+		bf:   bf,
+		kind: kind,
+		next: from,
+		to:   to,
+	}
+}
+
+func (it *rangeIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.batch) == 0 {
+		if it.next >= it.to {
+			it.value = nil
+			return false
+		}
+		count := it.to - it.next
+		if count > iteratorBatchItems {
+			count = iteratorBatchItems
+		}
+		it.batch, it.err = it.bf.GetRange(it.kind, it.next, it.next+count, iteratorBatchBytes)
+		if it.err != nil {
+			it.value = nil
+			return false
+		}
+	}
+	it.number, it.value = it.next, it.batch[0]
+	it.batch = it.batch[1:]
+	it.next++
+	return true
+}
+
+func (it *rangeIterator) Number() uint64 {
+	return it.number
+}
+
+func (it *rangeIterator) Value() []byte {
+	return it.value
+}
+
+func (it *rangeIterator) Error() error {
+	return it.err
+}
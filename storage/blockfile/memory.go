@@ -1,26 +1,33 @@
 package blockfile
 
 import (
+	"context"
 	"sync"
 
 	"github.com/pkg/errors"
 )
 
+// memory is a BlockFile in memory. Appends and truncations hold the write lock for the
+// whole operation, so the readers see either the blocks before or after it.
 type memory struct {
 	nextBlockNumber uint64
+	tail            uint64
 	tables          map[string]map[uint64][]byte
+	tableNames      []string
 	lock            sync.RWMutex
 }
 
-func NewMemory() BlockFile {
+func NewMemory(opts ...Option) BlockFile {
+	c := newConfig(opts)
 	tables := make(map[string]map[uint64][]byte)
-	for name := range BlockFileSchema {
+	for _, name := range c.schema {
 		tables[name] = map[uint64][]byte{}
 	}
 
 	return &memory{
 		nextBlockNumber: 0,
 		tables:          tables,
+		tableNames:      c.schema,
 		lock:            sync.RWMutex{},
 	}
 }
@@ -36,6 +43,9 @@ func (m *memory) Get(kind string, number uint64) ([]byte, error) {
 	defer m.lock.RUnlock()
 
 	if table := m.tables[kind]; table != nil {
+		if number < m.tail {
+			return nil, ErrBlockPruned
+		}
 		if number < m.nextBlockNumber {
 			return table[number], nil
 		}
@@ -44,34 +54,101 @@ func (m *memory) Get(kind string, number uint64) ([]byte, error) {
 	return nil, errors.New("unknown table")
 }
 
+func (m *memory) GetRange(kind string, from, to, maxBytes uint64) ([][]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	table := m.tables[kind]
+	if table == nil {
+		return nil, errors.New("unknown table")
+	}
+	if from < m.tail {
+		return nil, ErrBlockPruned
+	}
+	if to <= from {
+		return nil, errors.Errorf("invalid range [%d, %d)", from, to)
+	}
+	if from >= m.nextBlockNumber {
+		return nil, errors.New("out of bounds")
+	}
+	if to > m.nextBlockNumber {
+		to = m.nextBlockNumber
+	}
+
+	var (
+		items [][]byte
+		total uint64
+	)
+	for number := from; number < to; number++ {
+		item := table[number]
+		if maxBytes > 0 && len(items) > 0 && total+uint64(len(item)) > maxBytes {
+			break
+		}
+		total += uint64(len(item))
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (m *memory) Iterator(kind string, from, to uint64) Iterator {
+	return newRangeIterator(m, kind, from, to)
+}
+
 func (m *memory) AppendBlock(number uint64, hash, header, extra, receipts, transactions []byte) (err error) {
 	return m.BatchAppendBlock(number, [][]byte{hash}, [][]byte{header}, [][]byte{extra}, [][]byte{receipts}, [][]byte{transactions})
 }
 
 func (m *memory) BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) (err error) {
+	batchNum := len(listOfHeader)
+	if !(batchNum == len(listOfExtra) && batchNum == len(listOfReceipts) && batchNum == len(listOfTransactions) && batchNum == len(listOfHash)) {
+		return errors.New("doBatch append block data param's length not match")
+	}
+
+	records := make([]Record, batchNum)
+	for i := range records {
+		records[i] = Record{
+			BlockFileHeaderTable:   listOfHeader[i],
+			BlockFileTXsTable:      listOfTransactions[i],
+			BlockFileExtraTable:    listOfExtra[i],
+			BlockFileReceiptsTable: listOfReceipts[i],
+		}
+		if _, ok := m.tables[BlockFileHashTable]; ok {
+			records[i][BlockFileHashTable] = listOfHash[i]
+		}
+	}
+	return m.BatchAppendRecords(number, records)
+}
+
+func (m *memory) AppendRecord(number uint64, record Record) error {
+	return m.BatchAppendRecords(number, []Record{record})
+}
+
+func (m *memory) BatchAppendRecords(number uint64, records []Record) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	if m.nextBlockNumber != number {
 		return errors.New("the append operation is out-order")
 	}
-
-	batchNum := len(listOfHeader)
-	if batchNum == 0 {
+	if len(records) == 0 {
 		return errors.New("empty doBatch append block data")
 	}
-	if !(batchNum == len(listOfExtra) && batchNum == len(listOfReceipts) && batchNum == len(listOfTransactions) && batchNum == len(listOfHash)) {
-		return errors.New("doBatch append block data param's length not match")
+	for _, record := range records {
+		if err := validateRecord(m.tableNames, record); err != nil {
+			return err
+		}
 	}
 
-	for i := 0; i < batchNum; i++ {
+	for i, record := range records {
 		blockNumber := m.nextBlockNumber + uint64(i)
-		m.tables[BlockFileHeaderTable][blockNumber] = listOfHeader[i]
-		m.tables[BlockFileTXsTable][blockNumber] = listOfTransactions[i]
-		m.tables[BlockFileExtraTable][blockNumber] = listOfExtra[i]
-		m.tables[BlockFileReceiptsTable][blockNumber] = listOfReceipts[i]
+		for name, item := range record {
+			if item == nil {
+				item = []byte{}
+			}
+			m.tables[name][blockNumber] = item
+		}
 	}
-	m.nextBlockNumber += uint64(batchNum)
+	m.nextBlockNumber += uint64(len(records))
 	return nil
 }
 
@@ -82,18 +159,49 @@ func (m *memory) TruncateBlocks(targetBlock uint64) error {
 	if targetBlock >= m.nextBlockNumber {
 		return nil
 	}
+	if targetBlock+1 < m.tail {
+		return errors.Wrapf(ErrBlockPruned, "truncate blocks to %d below tail %d", targetBlock, m.tail)
+	}
 
 	for i := m.nextBlockNumber - 1; i > targetBlock; i-- {
-		delete(m.tables[BlockFileHeaderTable], i)
-		delete(m.tables[BlockFileTXsTable], i)
-		delete(m.tables[BlockFileExtraTable], i)
-		delete(m.tables[BlockFileReceiptsTable], i)
+		for _, table := range m.tables {
+			delete(table, i)
+		}
 	}
 	m.nextBlockNumber = targetBlock + 1
 
 	return nil
 }
 
+func (m *memory) TruncateTail(firstKeptBlock uint64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if firstKeptBlock <= m.tail {
+		return nil
+	}
+	if firstKeptBlock > m.nextBlockNumber {
+		return errors.Errorf("truncate tail to %d beyond next block %d", firstKeptBlock, m.nextBlockNumber)
+	}
+
+	for i := m.tail; i < firstKeptBlock; i++ {
+		for _, table := range m.tables {
+			delete(table, i)
+		}
+	}
+	m.tail = firstKeptBlock
+	return nil
+}
+
+// Verify reports nothing, the memory block file never corrupts.
+func (m *memory) Verify(ctx context.Context) (map[string][]uint64, error) {
+	return map[string][]uint64{}, ctx.Err()
+}
+
+func (m *memory) Sync() error {
+	return nil
+}
+
 func (m *memory) Close() error {
 	return nil
 }
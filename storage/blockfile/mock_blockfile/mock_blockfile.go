@@ -10,8 +10,10 @@
 package mock_blockfile
 
 import (
+	context "context"
 	reflect "reflect"
 
+	blockfile "github.com/axiomesh/axiom-kit/storage/blockfile"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -76,6 +78,44 @@ func (c *MockBlockFileAppendBlockCall) DoAndReturn(f func(uint64, []byte, []byte
 	return c
 }
 
+// AppendRecord mocks base method.
+func (m *MockBlockFile) AppendRecord(number uint64, record blockfile.Record) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendRecord", number, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AppendRecord indicates an expected call of AppendRecord.
+func (mr *MockBlockFileMockRecorder) AppendRecord(number, record any) *MockBlockFileAppendRecordCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendRecord", reflect.TypeOf((*MockBlockFile)(nil).AppendRecord), number, record)
+	return &MockBlockFileAppendRecordCall{Call: call}
+}
+
+// MockBlockFileAppendRecordCall wrap *gomock.Call
+type MockBlockFileAppendRecordCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileAppendRecordCall) Return(arg0 error) *MockBlockFileAppendRecordCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileAppendRecordCall) Do(f func(uint64, blockfile.Record) error) *MockBlockFileAppendRecordCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileAppendRecordCall) DoAndReturn(f func(uint64, blockfile.Record) error) *MockBlockFileAppendRecordCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // BatchAppendBlock mocks base method.
 func (m *MockBlockFile) BatchAppendBlock(number uint64, listOfHash, listOfHeader, listOfExtra, listOfReceipts, listOfTransactions [][]byte) error {
 	m.ctrl.T.Helper()
@@ -114,6 +154,44 @@ func (c *MockBlockFileBatchAppendBlockCall) DoAndReturn(f func(uint64, [][]byte,
 	return c
 }
 
+// BatchAppendRecords mocks base method.
+func (m *MockBlockFile) BatchAppendRecords(number uint64, records []blockfile.Record) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchAppendRecords", number, records)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BatchAppendRecords indicates an expected call of BatchAppendRecords.
+func (mr *MockBlockFileMockRecorder) BatchAppendRecords(number, records any) *MockBlockFileBatchAppendRecordsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchAppendRecords", reflect.TypeOf((*MockBlockFile)(nil).BatchAppendRecords), number, records)
+	return &MockBlockFileBatchAppendRecordsCall{Call: call}
+}
+
+// MockBlockFileBatchAppendRecordsCall wrap *gomock.Call
+type MockBlockFileBatchAppendRecordsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileBatchAppendRecordsCall) Return(arg0 error) *MockBlockFileBatchAppendRecordsCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileBatchAppendRecordsCall) Do(f func(uint64, []blockfile.Record) error) *MockBlockFileBatchAppendRecordsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileBatchAppendRecordsCall) DoAndReturn(f func(uint64, []blockfile.Record) error) *MockBlockFileBatchAppendRecordsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // Close mocks base method.
 func (m *MockBlockFile) Close() error {
 	m.ctrl.T.Helper()
@@ -191,6 +269,83 @@ func (c *MockBlockFileGetCall) DoAndReturn(f func(string, uint64) ([]byte, error
 	return c
 }
 
+// GetRange mocks base method.
+func (m *MockBlockFile) GetRange(kind string, from, to, maxBytes uint64) ([][]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRange", kind, from, to, maxBytes)
+	ret0, _ := ret[0].([][]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRange indicates an expected call of GetRange.
+func (mr *MockBlockFileMockRecorder) GetRange(kind, from, to, maxBytes any) *MockBlockFileGetRangeCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRange", reflect.TypeOf((*MockBlockFile)(nil).GetRange), kind, from, to, maxBytes)
+	return &MockBlockFileGetRangeCall{Call: call}
+}
+
+// MockBlockFileGetRangeCall wrap *gomock.Call
+type MockBlockFileGetRangeCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileGetRangeCall) Return(arg0 [][]byte, arg1 error) *MockBlockFileGetRangeCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileGetRangeCall) Do(f func(string, uint64, uint64, uint64) ([][]byte, error)) *MockBlockFileGetRangeCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileGetRangeCall) DoAndReturn(f func(string, uint64, uint64, uint64) ([][]byte, error)) *MockBlockFileGetRangeCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// Iterator mocks base method.
+func (m *MockBlockFile) Iterator(kind string, from, to uint64) blockfile.Iterator {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Iterator", kind, from, to)
+	ret0, _ := ret[0].(blockfile.Iterator)
+	return ret0
+}
+
+// Iterator indicates an expected call of Iterator.
+func (mr *MockBlockFileMockRecorder) Iterator(kind, from, to any) *MockBlockFileIteratorCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Iterator", reflect.TypeOf((*MockBlockFile)(nil).Iterator), kind, from, to)
+	return &MockBlockFileIteratorCall{Call: call}
+}
+
+// MockBlockFileIteratorCall wrap *gomock.Call
+type MockBlockFileIteratorCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileIteratorCall) Return(arg0 blockfile.Iterator) *MockBlockFileIteratorCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileIteratorCall) Do(f func(string, uint64, uint64) blockfile.Iterator) *MockBlockFileIteratorCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileIteratorCall) DoAndReturn(f func(string, uint64, uint64) blockfile.Iterator) *MockBlockFileIteratorCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // NextBlockNumber mocks base method.
 func (m *MockBlockFile) NextBlockNumber() uint64 {
 	m.ctrl.T.Helper()
@@ -229,6 +384,44 @@ func (c *MockBlockFileNextBlockNumberCall) DoAndReturn(f func() uint64) *MockBlo
 	return c
 }
 
+// Sync mocks base method.
+func (m *MockBlockFile) Sync() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sync")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Sync indicates an expected call of Sync.
+func (mr *MockBlockFileMockRecorder) Sync() *MockBlockFileSyncCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sync", reflect.TypeOf((*MockBlockFile)(nil).Sync))
+	return &MockBlockFileSyncCall{Call: call}
+}
+
+// MockBlockFileSyncCall wrap *gomock.Call
+type MockBlockFileSyncCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileSyncCall) Return(arg0 error) *MockBlockFileSyncCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileSyncCall) Do(f func() error) *MockBlockFileSyncCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileSyncCall) DoAndReturn(f func() error) *MockBlockFileSyncCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // TruncateBlocks mocks base method.
 func (m *MockBlockFile) TruncateBlocks(targetBlock uint64) error {
 	m.ctrl.T.Helper()
@@ -266,3 +459,80 @@ func (c *MockBlockFileTruncateBlocksCall) DoAndReturn(f func(uint64) error) *Moc
 	c.Call = c.Call.DoAndReturn(f)
 	return c
 }
+
+// TruncateTail mocks base method.
+func (m *MockBlockFile) TruncateTail(firstKeptBlock uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TruncateTail", firstKeptBlock)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TruncateTail indicates an expected call of TruncateTail.
+func (mr *MockBlockFileMockRecorder) TruncateTail(firstKeptBlock any) *MockBlockFileTruncateTailCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TruncateTail", reflect.TypeOf((*MockBlockFile)(nil).TruncateTail), firstKeptBlock)
+	return &MockBlockFileTruncateTailCall{Call: call}
+}
+
+// MockBlockFileTruncateTailCall wrap *gomock.Call
+type MockBlockFileTruncateTailCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileTruncateTailCall) Return(arg0 error) *MockBlockFileTruncateTailCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileTruncateTailCall) Do(f func(uint64) error) *MockBlockFileTruncateTailCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileTruncateTailCall) DoAndReturn(f func(uint64) error) *MockBlockFileTruncateTailCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// Verify mocks base method.
+func (m *MockBlockFile) Verify(ctx context.Context) (map[string][]uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", ctx)
+	ret0, _ := ret[0].(map[string][]uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockBlockFileMockRecorder) Verify(ctx any) *MockBlockFileVerifyCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockBlockFile)(nil).Verify), ctx)
+	return &MockBlockFileVerifyCall{Call: call}
+}
+
+// MockBlockFileVerifyCall wrap *gomock.Call
+type MockBlockFileVerifyCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockBlockFileVerifyCall) Return(arg0 map[string][]uint64, arg1 error) *MockBlockFileVerifyCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockBlockFileVerifyCall) Do(f func(context.Context) (map[string][]uint64, error)) *MockBlockFileVerifyCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockBlockFileVerifyCall) DoAndReturn(f func(context.Context) (map[string][]uint64, error)) *MockBlockFileVerifyCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
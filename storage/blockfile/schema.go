@@ -1,5 +1,9 @@
 package blockfile
 
+import (
+	"github.com/pkg/errors"
+)
+
 const (
 	// freezerBodiesTable indicates the name of the freezer block body table.
 	BlockFileHeaderTable = "header"
@@ -11,6 +15,9 @@ const (
 
 	// freezerReceiptTable indicates the name of the freezer receipts table.
 	BlockFileReceiptsTable = "receipts"
+
+	// BlockFileHashTable indicates the name of the block hash table, which is not in the default schema.
+	BlockFileHashTable = "hash"
 )
 
 var BlockFileSchema = map[string]bool{
@@ -19,3 +26,48 @@ var BlockFileSchema = map[string]bool{
 	BlockFileExtraTable:    true,
 	BlockFileReceiptsTable: true,
 }
+
+// Record holds the items of a block by table name.
+type Record map[string][]byte
+
+// WithSchema sets the tables of the block file instead of BlockFileSchema.
+// Tables added to an existing data dir are backfilled with empty items for the existing blocks,
+// and the tables removed from the schema are left untouched on disk.
+func WithSchema(tables ...string) Option {
+	return func(c *config) {
+		c.schema = nil
+		seen := make(map[string]bool, len(tables))
+		for _, table := range tables {
+			if !seen[table] {
+				seen[table] = true
+				c.schema = append(c.schema, table)
+			}
+		}
+	}
+}
+
+// validateRecord checks that the record has exactly the tables of the schema, a nil item is stored as empty.
+func validateRecord(schema []string, record Record) error {
+	for _, table := range schema {
+		if _, ok := record[table]; !ok {
+			return errors.Errorf("record misses table %s", table)
+		}
+	}
+	if len(record) != len(schema) {
+		for table := range record {
+			if !containsTable(schema, table) {
+				return errors.Errorf("unknown table %s", table)
+			}
+		}
+	}
+	return nil
+}
+
+func containsTable(schema []string, table string) bool {
+	for _, name := range schema {
+		if name == table {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package blockstore
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/axiomesh/axiom-kit/storage/blockfile"
+	"github.com/axiomesh/axiom-kit/types"
+)
+
+const defaultHeaderCacheSize = 1024
+
+// BlockStore stores the blocks and their receipts in a block file, encoded by types.
+type BlockStore interface {
+	// PutBlock appends the block with the receipts of its transactions.
+	PutBlock(block *types.Block, receipts []*types.Receipt) error
+
+	GetBlock(number uint64) (*types.Block, error)
+
+	// GetHeader returns the header of the block, the cached header must not be modified.
+	GetHeader(number uint64) (*types.BlockHeader, error)
+
+	// GetTransaction returns the transaction at the index of the block, decoding it only.
+	GetTransaction(number, index uint64) (*types.Transaction, error)
+
+	// GetReceipt returns the receipt of the transaction with the given hash.
+	GetReceipt(txHash *types.Hash) (*types.Receipt, error)
+
+	// TruncateBlocks drops the blocks above targetBlock.
+	TruncateBlocks(targetBlock uint64) error
+
+	Close() error
+}
+
+type Option func(s *blockStore)
+
+// WithHeaderCacheSize sets the number of the decoded headers cached, defaults to 1024.
+func WithHeaderCacheSize(size int) Option {
+	return func(s *blockStore) {
+		s.headerCacheSize = size
+	}
+}
+
+type blockStore struct {
+	bf              blockfile.IndexedBlockFile
+	headerCacheSize int
+	headers         *lru.Cache[uint64, *types.BlockHeader]
+
+	// truncateLock keeps the headers read or appended before a truncation out of the cache
+	truncateLock sync.RWMutex
+}
+
+// New returns a BlockStore on top of bf, the transaction hashes are looked up in its index.
+// Close closes bf.
+func New(bf blockfile.IndexedBlockFile, opts ...Option) BlockStore {
+	s := &blockStore{
+		bf:              bf,
+		headerCacheSize: defaultHeaderCacheSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.headerCacheSize <= 0 {
+		s.headerCacheSize = defaultHeaderCacheSize
+	}
+	s.headers = lru.NewCache[uint64, *types.BlockHeader](s.headerCacheSize)
+	return s
+}
+
+func (s *blockStore) PutBlock(block *types.Block, receipts []*types.Receipt) error {
+	if block == nil || block.Header == nil {
+		return errors.New("block without header")
+	}
+	if len(receipts) != len(block.Transactions) {
+		return errors.Errorf("%d receipts for %d transactions", len(receipts), len(block.Transactions))
+	}
+	number := block.Header.Number
+	header, err := block.Header.Marshal()
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal header of block %d", number)
+	}
+	var extra []byte
+	if block.Extra != nil {
+		if extra, err = block.Extra.Marshal(); err != nil {
+			return errors.Wrapf(err, "failed to marshal extra of block %d", number)
+		}
+	}
+	txs, err := types.MarshalTransactions(block.Transactions)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal transactions of block %d", number)
+	}
+	receiptsData, err := types.MarshalReceipts(receipts)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal receipts of block %d", number)
+	}
+
+	s.truncateLock.RLock()
+	defer s.truncateLock.RUnlock()
+	if err := s.bf.AppendBlock(number, block.Header.Hash().Bytes(), header, extra, receiptsData, txs); err != nil {
+		return err
+	}
+	s.headers.Add(number, block.Header.Clone())
+	return nil
+}
+
+func (s *blockStore) GetBlock(number uint64) (*types.Block, error) {
+	header, err := s.GetHeader(number)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.bf.Get(blockfile.BlockFileTXsTable, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get transactions of block %d", number)
+	}
+	txs, err := types.UnmarshalTransactions(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal transactions of block %d", number)
+	}
+	data, err = s.bf.Get(blockfile.BlockFileExtraTable, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get extra of block %d", number)
+	}
+	// a zero extra is stored empty, like a missing one
+	extra := &types.BlockExtra{}
+	if err := extra.Unmarshal(data); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal extra of block %d", number)
+	}
+	return &types.Block{
+		Header:       header.Clone(),
+		Transactions: txs,
+		Extra:        extra,
+	}, nil
+}
+
+func (s *blockStore) GetHeader(number uint64) (*types.BlockHeader, error) {
+	s.truncateLock.RLock()
+	defer s.truncateLock.RUnlock()
+	if number >= s.bf.NextBlockNumber() {
+		// the cache may hold the headers of the truncated blocks until they are removed
+		return nil, errors.Errorf("block %d out of bounds", number)
+	}
+	if header, ok := s.headers.Get(number); ok {
+		return header, nil
+	}
+	data, err := s.bf.Get(blockfile.BlockFileHeaderTable, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get header of block %d", number)
+	}
+	header := &types.BlockHeader{}
+	if err := header.Unmarshal(data); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal header of block %d", number)
+	}
+	s.headers.Add(number, header)
+	return header, nil
+}
+
+func (s *blockStore) GetTransaction(number, index uint64) (*types.Transaction, error) {
+	data, err := s.bf.Get(blockfile.BlockFileTXsTable, number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get transactions of block %d", number)
+	}
+	if err := checkIndex(data, index); err != nil {
+		return nil, errors.Wrapf(err, "transaction %d of block %d", index, number)
+	}
+	return types.UnmarshalTransactionWithIndex(data, index)
+}
+
+func (s *blockStore) GetReceipt(txHash *types.Hash) (*types.Receipt, error) {
+	meta, err := s.bf.GetTransactionMeta(txHash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.bf.Get(blockfile.BlockFileReceiptsTable, meta.BlockHeight)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get receipts of block %d", meta.BlockHeight)
+	}
+	if err := checkIndex(data, meta.Index); err != nil {
+		return nil, errors.Wrapf(err, "receipt %d of block %d", meta.Index, meta.BlockHeight)
+	}
+	return types.UnmarshalReceiptWithIndex(data, meta.Index)
+}
+
+func (s *blockStore) TruncateBlocks(targetBlock uint64) error {
+	s.truncateLock.Lock()
+	defer s.truncateLock.Unlock()
+	next := s.bf.NextBlockNumber()
+	if err := s.bf.TruncateBlocks(targetBlock); err != nil {
+		return err
+	}
+	for number := targetBlock + 1; number < next; number++ {
+		s.headers.Remove(number)
+	}
+	return nil
+}
+
+func (s *blockStore) Close() error {
+	s.headers.Purge()
+	return s.bf.Close()
+}
+
+// checkIndex checks the index against the number of the items encoded in data,
+// UnmarshalObjectsWithIndex panics on out of range indexes.
+func checkIndex(data []byte, index uint64) error {
+	count, err := countItems(data)
+	if err != nil {
+		return err
+	}
+	if index >= count {
+		return errors.Errorf("index out of range [0, %d)", count)
+	}
+	return nil
+}
+
+// countItems counts the items encoded in the pb.BytesSlice without decoding them.
+func countItems(data []byte) (uint64, error) {
+	var count uint64
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == 1 {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,185 @@
+package blockstore
+
+import (
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/axiomesh/axiom-kit/log"
+	"github.com/axiomesh/axiom-kit/storage/blockfile"
+	"github.com/axiomesh/axiom-kit/storage/kv"
+	"github.com/axiomesh/axiom-kit/types"
+)
+
+func newTestBlockStore(t *testing.T, opts ...Option) BlockStore {
+	bf, err := blockfile.NewBlockFile(filepath.Join(t.TempDir(), "blockfile"), log.NewWithModule("blockstore_test"))
+	assert.Nil(t, err)
+	ib, err := blockfile.NewIndexed(bf, kv.NewMemory())
+	assert.Nil(t, err)
+	return New(ib, opts...)
+}
+
+func newTestBlock(t *testing.T, signer *types.Signer, number uint64, txCount int) (*types.Block, []*types.Receipt) {
+	block := &types.Block{
+		Header: &types.BlockHeader{
+			Number:       number,
+			Timestamp:    int64(number),
+			TotalGasFee:  big.NewInt(0),
+			GasFeeReward: big.NewInt(0),
+		},
+		Extra: &types.BlockExtra{Size: int64(number)},
+	}
+	var receipts []*types.Receipt
+	for i := 0; i < txCount; i++ {
+		tx, err := types.GenerateTransactionWithGasPrice(number*uint64(txCount)+uint64(i), 21000, big.NewInt(1), signer)
+		assert.Nil(t, err)
+		block.Transactions = append(block.Transactions, tx)
+		receipts = append(receipts, &types.Receipt{
+			TxHash:            tx.GetHash(),
+			GasUsed:           uint64(i),
+			EffectiveGasPrice: big.NewInt(1),
+		})
+	}
+	return block, receipts
+}
+
+func TestBlockStore(t *testing.T) {
+	signer, err := types.GenerateSigner()
+	assert.Nil(t, err)
+	s := newTestBlockStore(t, WithHeaderCacheSize(2))
+	defer s.Close()
+
+	var blocks []*types.Block
+	var receipts [][]*types.Receipt
+	for number := uint64(0); number < 4; number++ {
+		block, list := newTestBlock(t, signer, number, 3)
+		assert.Nil(t, s.PutBlock(block, list))
+		blocks = append(blocks, block)
+		receipts = append(receipts, list)
+	}
+
+	for number, block := range blocks {
+		header, err := s.GetHeader(uint64(number))
+		assert.Nil(t, err)
+		assert.Equal(t, block.Header.Hash().String(), header.Hash().String())
+
+		got, err := s.GetBlock(uint64(number))
+		assert.Nil(t, err)
+		assert.Equal(t, block.Header.Hash().String(), got.Header.Hash().String())
+		assert.Equal(t, block.Extra.Size, got.Extra.Size)
+		assert.Len(t, got.Transactions, 3)
+
+		for i, tx := range block.Transactions {
+			got, err := s.GetTransaction(uint64(number), uint64(i))
+			assert.Nil(t, err)
+			assert.Equal(t, tx.GetHash().String(), got.GetHash().String())
+
+			receipt, err := s.GetReceipt(tx.GetHash())
+			assert.Nil(t, err)
+			assert.Equal(t, receipts[number][i].TxHash.String(), receipt.TxHash.String())
+			assert.EqualValues(t, i, receipt.GasUsed)
+		}
+		_, err = s.GetTransaction(uint64(number), 3)
+		assert.ErrorContains(t, err, "index out of range")
+	}
+
+	_, err = s.GetHeader(4)
+	assert.Error(t, err)
+	_, err = s.GetReceipt(types.NewHashByStr("0x1"))
+	assert.ErrorIs(t, err, kv.ErrorNotFound)
+
+	// the truncated blocks are neither cached nor indexed
+	assert.Nil(t, s.TruncateBlocks(1))
+	_, err = s.GetHeader(2)
+	assert.Error(t, err)
+	_, err = s.GetReceipt(blocks[3].Transactions[0].GetHash())
+	assert.ErrorIs(t, err, kv.ErrorNotFound)
+	block, list := newTestBlock(t, signer, 2, 1)
+	block.Header.Timestamp = 100
+	assert.Nil(t, s.PutBlock(block, list))
+	header, err := s.GetHeader(2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 100, header.Timestamp)
+}
+
+func TestBlockStoreGetBlockCopy(t *testing.T) {
+	signer, err := types.GenerateSigner()
+	assert.Nil(t, err)
+	s := newTestBlockStore(t)
+	defer s.Close()
+
+	block, receipts := newTestBlock(t, signer, 0, 1)
+	assert.Nil(t, s.PutBlock(block, receipts))
+
+	// the returned block owns its header, the cached one is untouched
+	got, err := s.GetBlock(0)
+	assert.Nil(t, err)
+	got.Header.Timestamp = 100
+	header, err := s.GetHeader(0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, header.Timestamp)
+}
+
+func TestBlockStoreTruncateConcurrentReads(t *testing.T) {
+	signer, err := types.GenerateSigner()
+	assert.Nil(t, err)
+	s := newTestBlockStore(t)
+	defer s.Close()
+
+	for number := uint64(0); number < 4; number++ {
+		block, list := newTestBlock(t, signer, number, 0)
+		assert.Nil(t, s.PutBlock(block, list))
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _ = s.GetHeader(3)
+			}
+		}
+	}()
+	for round := int64(1); round <= 50; round++ {
+		assert.Nil(t, s.TruncateBlocks(2))
+		block, list := newTestBlock(t, signer, 3, 0)
+		block.Header.Timestamp = round
+		assert.Nil(t, s.PutBlock(block, list))
+
+		// a reader racing with the truncation never caches the dropped header
+		header, err := s.GetHeader(3)
+		assert.Nil(t, err)
+		assert.EqualValues(t, round, header.Timestamp)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestBlockStorePutBlockInvalid(t *testing.T) {
+	signer, err := types.GenerateSigner()
+	assert.Nil(t, err)
+	s := newTestBlockStore(t)
+	defer s.Close()
+
+	assert.Error(t, s.PutBlock(&types.Block{}, nil))
+	block, receipts := newTestBlock(t, signer, 0, 2)
+	assert.Error(t, s.PutBlock(block, receipts[:1]))
+	block, receipts = newTestBlock(t, signer, 1, 2)
+	assert.Error(t, s.PutBlock(block, receipts))
+
+	// a block without extra nor transactions
+	block = &types.Block{Header: &types.BlockHeader{TotalGasFee: big.NewInt(0), GasFeeReward: big.NewInt(0)}}
+	assert.Nil(t, s.PutBlock(block, nil))
+	got, err := s.GetBlock(0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, got.Extra.Size)
+	assert.Empty(t, got.Transactions)
+}
@@ -0,0 +1,241 @@
+package kv
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Codec is the compression algorithm of a value, it is stored in the header of the value.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZstd
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// compressedMagic starts the header magic(4) | codec(1) of the values written with a header.
+// The other values are stored as is, like the ones written before the wrapper was used.
+const compressedMagic = "\x89KVZ"
+
+const compressedHeaderSize = len(compressedMagic) + 1
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type CompressedOption func(c *compressed)
+
+// WithCompressionMetrics reports the compression ratio of the values written to the storage,
+// the collectors are registered to registry with a const label db=name.
+func WithCompressionMetrics(registry prometheus.Registerer, name string) CompressedOption {
+	return func(c *compressed) {
+		c.registry = registry
+		c.name = name
+	}
+}
+
+type compressed struct {
+	db      Storage
+	codec   Codec
+	minSize int
+
+	rawBytes    atomic.Int64
+	storedBytes atomic.Int64
+
+	name       string
+	registry   prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+// NewCompressed wraps db so that values of at least minSize bytes are compressed with codec.
+// The compressed values are prefixed with a header holding a magic and their Codec, so
+// values written with different codecs can be read back regardless of the configured
+// codec. The values not worth compressing are stored as is, so the values written to db
+// without the wrapper stay readable, unless they start with the magic by chance.
+// Reading a value with the magic which fails to decode panics, like reading a corrupted value.
+func NewCompressed(db Storage, codec Codec, minSize int, opts ...CompressedOption) (Storage, error) {
+	if codec > CodecZstd {
+		return nil, errors.Wrapf(ErrUnknownCodec, "codec %d", codec)
+	}
+	c := &compressed{
+		db:      db,
+		codec:   codec,
+		minSize: minSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.registry != nil {
+		labels := prometheus.Labels{"db": c.name}
+		c.collectors = []prometheus.Collector{
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "kv_compression_raw_bytes_total",
+				Help:        "size of the values before compression",
+				ConstLabels: labels,
+			}, func() float64 { return float64(c.rawBytes.Load()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "kv_compression_stored_bytes_total",
+				Help:        "size of the values after compression, including the header",
+				ConstLabels: labels,
+			}, func() float64 { return float64(c.storedBytes.Load()) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "kv_compression_ratio",
+				Help:        "ratio of the raw size to the stored size of the written values",
+				ConstLabels: labels,
+			}, c.Ratio),
+		}
+		if err := registerCollectors(c.registry, c.collectors...); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Ratio returns the ratio of the raw size to the stored size of the values written so far.
+func (c *compressed) Ratio() float64 {
+	stored := c.storedBytes.Load()
+	if stored == 0 {
+		return 1
+	}
+	return float64(c.rawBytes.Load()) / float64(stored)
+}
+
+func (c *compressed) encode(value []byte) []byte {
+	var encoded []byte
+	if c.codec != CodecNone && len(value) >= c.minSize {
+		switch c.codec {
+		case CodecSnappy:
+			encoded = snappy.Encode(nil, value)
+		case CodecZstd:
+			encoded = zstdEncoder.EncodeAll(value, make([]byte, 0, len(value)))
+		}
+	}
+
+	var stored []byte
+	switch {
+	case encoded != nil && compressedHeaderSize+len(encoded) < len(value):
+		stored = header(c.codec, encoded)
+	case bytes.HasPrefix(value, []byte(compressedMagic)):
+		// not worth compressing, but it would be taken for a header
+		stored = header(CodecNone, value)
+	default:
+		stored = value
+	}
+	c.rawBytes.Add(int64(len(value)))
+	c.storedBytes.Add(int64(len(stored)))
+	return stored
+}
+
+func header(codec Codec, data []byte) []byte {
+	stored := make([]byte, compressedHeaderSize+len(data))
+	copy(stored, compressedMagic)
+	stored[len(compressedMagic)] = byte(codec)
+	copy(stored[compressedHeaderSize:], data)
+	return stored
+}
+
+// decode returns the value of stored, the values without the magic are returned as is.
+func decode(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, []byte(compressedMagic)) {
+		return stored, nil
+	}
+	if len(stored) < compressedHeaderSize {
+		return nil, errors.New("compressed value too short")
+	}
+	data := stored[compressedHeaderSize:]
+	switch codec := Codec(stored[len(compressedMagic)]); codec {
+	case CodecNone:
+		return data, nil
+	case CodecSnappy:
+		return snappy.Decode(nil, data)
+	case CodecZstd:
+		// keep empty values non-nil
+		return zstdDecoder.DecodeAll(data, []byte{})
+	default:
+		return nil, errors.Wrapf(ErrUnknownCodec, "codec %d", codec)
+	}
+}
+
+func mustDecode(stored []byte) []byte {
+	value, err := decode(stored)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to decompress value"))
+	}
+	return value
+}
+
+func (c *compressed) Put(key, value []byte) {
+	c.db.Put(key, c.encode(value))
+}
+
+func (c *compressed) Delete(key []byte) {
+	c.db.Delete(key)
+}
+
+func (c *compressed) Get(key []byte) []byte {
+	return mustDecode(c.db.Get(key))
+}
+
+func (c *compressed) Has(key []byte) bool {
+	return c.db.Has(key)
+}
+
+func (c *compressed) Iterator(start, end []byte) Iterator {
+	return &compressedIterator{Iterator: c.db.Iterator(start, end)}
+}
+
+func (c *compressed) Prefix(prefix []byte) Iterator {
+	return &compressedIterator{Iterator: c.db.Prefix(prefix)}
+}
+
+func (c *compressed) NewBatch() Batch {
+	return &compressedBatch{Batch: c.db.NewBatch(), c: c}
+}
+
+func (c *compressed) Close() error {
+	unregisterCollectors(c.registry, c.collectors...)
+	return c.db.Close()
+}
+
+type compressedIterator struct {
+	Iterator
+}
+
+func (it *compressedIterator) Value() []byte {
+	return mustDecode(it.Iterator.Value())
+}
+
+type compressedBatch struct {
+	Batch
+	c    *compressed
+	size int
+}
+
+func (b *compressedBatch) Put(key, value []byte) {
+	b.Batch.Put(key, b.c.encode(value))
+	b.size += len(key) + len(value)
+}
+
+func (b *compressedBatch) Delete(key []byte) {
+	b.Batch.Delete(key)
+	b.size += len(key)
+}
+
+func (b *compressedBatch) Size() int {
+	return b.size
+}
+
+func (b *compressedBatch) Reset() {
+	b.Batch.Reset()
+	b.size = 0
+}
@@ -0,0 +1,157 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressed(t *testing.T) {
+	for name, codec := range map[string]Codec{"none": CodecNone, "snappy": CodecSnappy, "zstd": CodecZstd} {
+		t.Run(name, func(t *testing.T) {
+			db := NewMemory()
+			s, err := NewCompressed(db, codec, 64)
+			require.Nil(t, err)
+
+			large := bytes.Repeat([]byte("receipt"), 100)
+			s.Put([]byte("large"), large)
+			s.Put([]byte("small"), []byte("value"))
+			s.Put([]byte("empty"), []byte{})
+			assert.EqualValues(t, large, s.Get([]byte("large")))
+			assert.EqualValues(t, []byte("value"), s.Get([]byte("small")))
+			assert.NotNil(t, s.Get([]byte("empty")))
+			assert.Empty(t, s.Get([]byte("empty")))
+			assert.Nil(t, s.Get([]byte("none")))
+			// the values not worth compressing are stored as is
+			assert.EqualValues(t, []byte("value"), db.Get([]byte("small")))
+			if codec != CodecNone {
+				assert.EqualValues(t, compressedMagic, db.Get([]byte("large"))[:len(compressedMagic)])
+				assert.EqualValues(t, codec, db.Get([]byte("large"))[len(compressedMagic)])
+				assert.Less(t, len(db.Get([]byte("large"))), len(large))
+				assert.Greater(t, s.(*compressed).Ratio(), float64(1))
+			}
+
+			batch := s.NewBatch()
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("key%d", i)
+				batch.Put([]byte(key), bytes.Repeat([]byte(key), i*10))
+			}
+			batch.Commit()
+			iter := s.Prefix([]byte("key"))
+			i := 0
+			for iter.Next() {
+				key := fmt.Sprintf("key%d", i)
+				assert.EqualValues(t, []byte(key), iter.Key())
+				assert.EqualValues(t, bytes.Repeat([]byte(key), i*10), iter.Value())
+				i++
+			}
+			assert.EqualValues(t, 10, i)
+		})
+	}
+}
+
+func TestCompressed_MixedCodecs(t *testing.T) {
+	db := NewMemory()
+	value := bytes.Repeat([]byte("value"), 100)
+
+	snappyDB, err := NewCompressed(db, CodecSnappy, 0)
+	require.Nil(t, err)
+	snappyDB.Put([]byte("snappy"), value)
+
+	zstdDB, err := NewCompressed(db, CodecZstd, 0)
+	require.Nil(t, err)
+	zstdDB.Put([]byte("zstd"), value)
+	assert.EqualValues(t, value, zstdDB.Get([]byte("snappy")))
+	assert.EqualValues(t, value, snappyDB.Get([]byte("zstd")))
+
+	_, err = NewCompressed(db, Codec(0xff), 0)
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+}
+
+func TestCompressed_RawValues(t *testing.T) {
+	db := NewMemory()
+	// values written before the wrapper was used
+	raw := map[string][]byte{
+		"none":    {0x00, 'a'},
+		"snappy":  {0x01, 'b'},
+		"zstd":    {0x02, 'c'},
+		"unknown": {0xff},
+		"empty":   {},
+	}
+	for key, value := range raw {
+		db.Put([]byte("raw-"+key), value)
+	}
+
+	s, err := NewCompressed(db, CodecZstd, 0)
+	require.Nil(t, err)
+	large := bytes.Repeat([]byte("value"), 100)
+	s.Put([]byte("compressed"), large)
+	// a value taken for a header is written with one
+	s.Put([]byte("written-magic"), []byte(compressedMagic+"\x01"))
+
+	for key, value := range raw {
+		assert.EqualValues(t, value, s.Get([]byte("raw-"+key)), key)
+	}
+	assert.EqualValues(t, large, s.Get([]byte("compressed")))
+	assert.EqualValues(t, []byte(compressedMagic+"\x01"), s.Get([]byte("written-magic")))
+
+	iter := s.Prefix([]byte("raw-"))
+	count := 0
+	for iter.Next() {
+		assert.EqualValues(t, raw[string(iter.Key()[len("raw-"):])], iter.Value())
+		count++
+	}
+	assert.EqualValues(t, len(raw), count)
+
+	// the values with the magic failing to decode are not returned as values
+	db.Put([]byte("broken"), []byte(compressedMagic+"\x01broken"))
+	db.Put([]byte("truncated"), []byte(compressedMagic))
+	db.Put([]byte("unknown"), []byte(compressedMagic+"\x7fvalue"))
+	assert.PanicsWithError(t, "failed to decompress value: snappy: corrupt input", func() { s.Get([]byte("broken")) })
+	assert.PanicsWithError(t, "failed to decompress value: compressed value too short", func() { s.Get([]byte("truncated")) })
+	assert.PanicsWithError(t, "failed to decompress value: codec 127: unknown codec", func() { s.Get([]byte("unknown")) })
+	iter = s.Prefix([]byte("broken"))
+	require.True(t, iter.Next())
+	assert.Panics(t, func() { iter.Value() })
+}
+
+func TestCompressed_Metrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	s, err := NewCompressed(NewMemory(), CodecZstd, 0, WithCompressionMetrics(registry, "receipts"))
+	require.Nil(t, err)
+	_, err = NewCompressed(NewMemory(), CodecZstd, 0, WithCompressionMetrics(registry, "receipts"))
+	require.NotNil(t, err)
+
+	s.Put([]byte("key"), bytes.Repeat([]byte("value"), 100))
+	c := s.(*compressed)
+	assert.EqualValues(t, 500, testutil.ToFloat64(c.collectors[0]))
+	assert.EqualValues(t, c.Ratio(), testutil.ToFloat64(c.collectors[2]))
+
+	require.Nil(t, s.Close())
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	assert.Empty(t, families)
+}
+
+func TestCompressed_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		s, err := NewCompressed(NewMemory(), CodecZstd, 0)
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func BenchmarkCompressedSuite(b *testing.B) {
+	BenchKvSuite(b, func() Storage {
+		s, err := NewCompressed(NewMemory(), CodecSnappy, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return s
+	})
+}
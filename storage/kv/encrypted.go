@@ -0,0 +1,404 @@
+package kv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher is the AEAD algorithm used to seal values.
+type Cipher byte
+
+const (
+	CipherAESGCM Cipher = iota + 1
+	CipherChaCha20Poly1305
+)
+
+const (
+	// cipher(1) + key id(4)
+	encryptedHeaderSize = 5
+
+	rotateBatchSize = 1000
+)
+
+var (
+	ErrUnknownCipher = errors.New("unknown cipher")
+	ErrKeyNotFound   = errors.New("encryption key not found")
+	ErrHashedKeys    = errors.New("range iteration is not supported with hashed keys")
+)
+
+// KeyProvider supplies the data encryption keys of an encrypted storage.
+type KeyProvider interface {
+	// CurrentKey returns the key used to seal new values and its id.
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Key returns the key of the given id, it is used to open existing values.
+	Key(id uint32) ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider holding a fixed set of keys,
+// values are sealed with the key of id current.
+func NewStaticKeyProvider(current uint32, keys map[uint32][]byte) KeyProvider {
+	return &staticKeyProvider{
+		current: current,
+		keys:    keys,
+	}
+}
+
+func (p *staticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.current)
+	return p.current, key, err
+}
+
+func (p *staticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrKeyNotFound, "key id %d", id)
+	}
+	return key, nil
+}
+
+// EncryptedStorage is a Storage whose values are sealed at rest.
+type EncryptedStorage interface {
+	Storage
+
+	// Rotate re-encrypts every value that is not sealed by the current key of
+	// the KeyProvider, and returns the number of re-encrypted values. The values
+	// whose cipher is unknown or whose key id is not found by the KeyProvider, like
+	// the ones of other users of a shared db, are left untouched and counted as
+	// skipped, while a value failing to decrypt with a known key is an error.
+	// The writes wait for the chunk being rotated, so they are never overwritten.
+	// Old keys must stay available from the KeyProvider until Rotate returns.
+	Rotate() (rotated int, skipped int, err error)
+}
+
+type EncryptedOption func(e *encrypted)
+
+// WithCipher sets the AEAD used to seal new values, defaults to CipherAESGCM.
+// Values sealed by other ciphers remain readable.
+func WithCipher(c Cipher) EncryptedOption {
+	return func(e *encrypted) {
+		e.cipher = c
+	}
+}
+
+// WithHashedKeys stores keys as HMAC-SHA256(secret, key) so that they are not readable at rest.
+// The secret must never change for a given storage. Iterator and Prefix panic with
+// ErrHashedKeys because hashing does not preserve the key order.
+func WithHashedKeys(secret []byte) EncryptedOption {
+	return func(e *encrypted) {
+		e.hashSecret = secret
+	}
+}
+
+type encrypted struct {
+	db         Storage
+	provider   KeyProvider
+	cipher     Cipher
+	hashSecret []byte
+
+	lock  sync.RWMutex
+	aeads map[aeadID]cipher.AEAD
+
+	// rotateLock is held by the writes, and exclusively by Rotate while it rotates a chunk
+	rotateLock sync.RWMutex
+}
+
+type aeadID struct {
+	cipher Cipher
+	keyID  uint32
+}
+
+// NewEncrypted wraps db so that every value is sealed with AES-GCM or ChaCha20-Poly1305
+// before it reaches db, the stored key is bound to the value as additional data.
+func NewEncrypted(db Storage, provider KeyProvider, opts ...EncryptedOption) (EncryptedStorage, error) {
+	e := &encrypted{
+		db:       db,
+		provider: provider,
+		cipher:   CipherAESGCM,
+		aeads:    make(map[aeadID]cipher.AEAD),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	// fail fast on a misconfigured cipher or key
+	id, _, err := provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.aead(e.cipher, id); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encrypted) aead(c Cipher, keyID uint32) (cipher.AEAD, error) {
+	id := aeadID{cipher: c, keyID: keyID}
+	e.lock.RLock()
+	aead, ok := e.aeads[id]
+	e.lock.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := e.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	case CipherChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Wrapf(ErrUnknownCipher, "cipher %d", c)
+	}
+
+	e.lock.Lock()
+	e.aeads[id] = aead
+	e.lock.Unlock()
+	return aead, nil
+}
+
+func (e *encrypted) storedKey(key []byte) []byte {
+	if e.hashSecret == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, e.hashSecret)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// seal encodes value as cipher(1) | key id(4) | nonce | ciphertext.
+func (e *encrypted) seal(storedKey, value []byte) []byte {
+	keyID, _, err := e.provider.CurrentKey()
+	if err != nil {
+		panic(errors.Wrap(err, "failed to get current encryption key"))
+	}
+	aead, err := e.aead(e.cipher, keyID)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to init encryption cipher"))
+	}
+
+	nonceSize := aead.NonceSize()
+	sealed := make([]byte, encryptedHeaderSize+nonceSize, encryptedHeaderSize+nonceSize+len(value)+aead.Overhead())
+	sealed[0] = byte(e.cipher)
+	binary.BigEndian.PutUint32(sealed[1:encryptedHeaderSize], keyID)
+	nonce := sealed[encryptedHeaderSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic(errors.Wrap(err, "failed to generate nonce"))
+	}
+	return aead.Seal(sealed, nonce, value, storedKey)
+}
+
+func (e *encrypted) open(storedKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < encryptedHeaderSize {
+		return nil, errors.New("encrypted value too short")
+	}
+	aead, err := e.aead(Cipher(sealed[0]), binary.BigEndian.Uint32(sealed[1:encryptedHeaderSize]))
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(sealed) < encryptedHeaderSize+nonceSize {
+		return nil, errors.New("encrypted value too short")
+	}
+	nonce := sealed[encryptedHeaderSize : encryptedHeaderSize+nonceSize]
+	ciphertext := sealed[encryptedHeaderSize+nonceSize:]
+	// keep empty values non-nil
+	return aead.Open(make([]byte, 0, len(ciphertext)), nonce, ciphertext, storedKey)
+}
+
+func (e *encrypted) mustOpen(storedKey, sealed []byte) []byte {
+	if sealed == nil {
+		return nil
+	}
+	value, err := e.open(storedKey, sealed)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to decrypt value"))
+	}
+	return value
+}
+
+func (e *encrypted) Put(key, value []byte) {
+	storedKey := e.storedKey(key)
+	sealed := e.seal(storedKey, value)
+	e.rotateLock.RLock()
+	defer e.rotateLock.RUnlock()
+	e.db.Put(storedKey, sealed)
+}
+
+func (e *encrypted) Delete(key []byte) {
+	storedKey := e.storedKey(key)
+	e.rotateLock.RLock()
+	defer e.rotateLock.RUnlock()
+	e.db.Delete(storedKey)
+}
+
+func (e *encrypted) Get(key []byte) []byte {
+	storedKey := e.storedKey(key)
+	return e.mustOpen(storedKey, e.db.Get(storedKey))
+}
+
+func (e *encrypted) Has(key []byte) bool {
+	return e.db.Has(e.storedKey(key))
+}
+
+func (e *encrypted) Iterator(start, end []byte) Iterator {
+	if e.hashSecret != nil {
+		panic(ErrHashedKeys)
+	}
+	return &encryptedIterator{Iterator: e.db.Iterator(start, end), e: e}
+}
+
+func (e *encrypted) Prefix(prefix []byte) Iterator {
+	if e.hashSecret != nil {
+		panic(ErrHashedKeys)
+	}
+	return &encryptedIterator{Iterator: e.db.Prefix(prefix), e: e}
+}
+
+func (e *encrypted) NewBatch() Batch {
+	return &encryptedBatch{Batch: e.db.NewBatch(), e: e}
+}
+
+func (e *encrypted) Close() error {
+	return e.db.Close()
+}
+
+func (e *encrypted) Rotate() (int, int, error) {
+	keyID, _, err := e.provider.CurrentKey()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		rotated int
+		skipped int
+		keys    [][]byte
+		it      = e.db.Prefix(nil)
+	)
+	for it.Next() {
+		if e.sealedBy(it.Value(), keyID) {
+			continue
+		}
+		// the iterator may reuse its key buffer
+		keys = append(keys, append([]byte(nil), it.Key()...))
+		if len(keys) == rotateBatchSize {
+			n, skip, err := e.rotate(keys, keyID)
+			rotated, skipped = rotated+n, skipped+skip
+			if err != nil {
+				return rotated, skipped, err
+			}
+			keys = keys[:0]
+		}
+	}
+	n, skip, err := e.rotate(keys, keyID)
+	return rotated + n, skipped + skip, err
+}
+
+// rotate re-encrypts the values of the stored keys in a single batch. The values are
+// read again with the writes held off, as they may have changed since they were listed.
+func (e *encrypted) rotate(storedKeys [][]byte, keyID uint32) (rotated int, skipped int, err error) {
+	e.rotateLock.Lock()
+	defer e.rotateLock.Unlock()
+
+	batch := e.db.NewBatch()
+	for _, storedKey := range storedKeys {
+		sealed := e.db.Get(storedKey)
+		if sealed == nil || e.sealedBy(sealed, keyID) {
+			continue
+		}
+		if len(sealed) < encryptedHeaderSize {
+			// not sealed by this storage
+			skipped++
+			continue
+		}
+		if _, err := e.aead(Cipher(sealed[0]), binary.BigEndian.Uint32(sealed[1:encryptedHeaderSize])); err != nil {
+			if errors.Is(err, ErrUnknownCipher) || errors.Is(err, ErrKeyNotFound) {
+				// not sealed by this storage
+				skipped++
+				continue
+			}
+			return 0, skipped, err
+		}
+		value, err := e.open(storedKey, sealed)
+		if err != nil {
+			return 0, skipped, errors.Wrapf(err, "failed to decrypt value of stored key %x", storedKey)
+		}
+		batch.Put(storedKey, e.seal(storedKey, value))
+		rotated++
+	}
+	batch.Commit()
+	return rotated, skipped, nil
+}
+
+// sealedBy returns whether the value is sealed by the cipher of the storage and the key.
+func (e *encrypted) sealedBy(sealed []byte, keyID uint32) bool {
+	return len(sealed) >= encryptedHeaderSize &&
+		Cipher(sealed[0]) == e.cipher && binary.BigEndian.Uint32(sealed[1:encryptedHeaderSize]) == keyID
+}
+
+type encryptedIterator struct {
+	Iterator
+	e *encrypted
+}
+
+func (it *encryptedIterator) Value() []byte {
+	return it.e.mustOpen(it.Iterator.Key(), it.Iterator.Value())
+}
+
+type encryptedBatch struct {
+	Batch
+	e    *encrypted
+	size int
+}
+
+func (b *encryptedBatch) Put(key, value []byte) {
+	storedKey := b.e.storedKey(key)
+	b.Batch.Put(storedKey, b.e.seal(storedKey, value))
+	b.size += len(key) + len(value)
+}
+
+func (b *encryptedBatch) Delete(key []byte) {
+	b.Batch.Delete(b.e.storedKey(key))
+	b.size += len(key)
+}
+
+func (b *encryptedBatch) Commit() {
+	b.e.rotateLock.RLock()
+	defer b.e.rotateLock.RUnlock()
+	b.Batch.Commit()
+}
+
+func (b *encryptedBatch) Size() int {
+	return b.size
+}
+
+func (b *encryptedBatch) Reset() {
+	b.Batch.Reset()
+	b.size = 0
+}
@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys() map[uint32][]byte {
+	return map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	for name, c := range map[string]Cipher{"aes-gcm": CipherAESGCM, "chacha20-poly1305": CipherChaCha20Poly1305} {
+		t.Run(name, func(t *testing.T) {
+			db := NewMemory()
+			s, err := NewEncrypted(db, NewStaticKeyProvider(1, testKeys()), WithCipher(c))
+			require.Nil(t, err)
+
+			s.Put([]byte("key"), []byte("value"))
+			assert.EqualValues(t, []byte("value"), s.Get([]byte("key")))
+			assert.NotContains(t, string(db.Get([]byte("key"))), "value")
+			assert.True(t, s.Has([]byte("key")))
+			s.Put([]byte("empty"), []byte{})
+			assert.NotNil(t, s.Get([]byte("empty")))
+			assert.Empty(t, s.Get([]byte("empty")))
+			s.Delete([]byte("key"))
+			assert.Nil(t, s.Get([]byte("key")))
+			assert.False(t, s.Has([]byte("key")))
+
+			batch := s.NewBatch()
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("key%d", i)
+				batch.Put([]byte(key), []byte(key))
+			}
+			batch.Delete([]byte("key5"))
+			batch.Commit()
+
+			iter := s.Prefix([]byte("key"))
+			expected := []string{"key0", "key1", "key2", "key3", "key4", "key6", "key7", "key8", "key9"}
+			i := 0
+			for iter.Next() {
+				assert.EqualValues(t, []byte(expected[i]), iter.Key())
+				assert.EqualValues(t, []byte(expected[i]), iter.Value())
+				i++
+			}
+			assert.EqualValues(t, len(expected), i)
+		})
+	}
+}
+
+func TestEncrypted_Tamper(t *testing.T) {
+	db := NewMemory()
+	s, err := NewEncrypted(db, NewStaticKeyProvider(1, testKeys()))
+	require.Nil(t, err)
+
+	// values are bound to their keys
+	s.Put([]byte("a"), []byte("value"))
+	db.Put([]byte("b"), db.Get([]byte("a")))
+	assert.Panics(t, func() { s.Get([]byte("b")) })
+
+	_, err = NewEncrypted(db, NewStaticKeyProvider(3, testKeys()))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = NewEncrypted(db, NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("short")}))
+	assert.NotNil(t, err)
+}
+
+func TestEncrypted_HashedKeys(t *testing.T) {
+	db := NewMemory()
+	s, err := NewEncrypted(db, NewStaticKeyProvider(1, testKeys()), WithHashedKeys([]byte("secret")))
+	require.Nil(t, err)
+
+	s.Put([]byte("key"), []byte("value"))
+	assert.EqualValues(t, []byte("value"), s.Get([]byte("key")))
+	assert.False(t, db.Has([]byte("key")))
+	assert.PanicsWithError(t, ErrHashedKeys.Error(), func() { s.Prefix(nil) })
+
+	rotated, err := NewEncrypted(db, NewStaticKeyProvider(2, testKeys()), WithHashedKeys([]byte("secret")), WithCipher(CipherChaCha20Poly1305))
+	require.Nil(t, err)
+	n, skipped, err := rotated.Rotate()
+	require.Nil(t, err)
+	assert.EqualValues(t, 1, n)
+	assert.EqualValues(t, 0, skipped)
+	assert.EqualValues(t, []byte("value"), rotated.Get([]byte("key")))
+}
+
+func TestEncrypted_Rotate(t *testing.T) {
+	db := NewMemory()
+	keys := testKeys()
+	old, err := NewEncrypted(db, NewStaticKeyProvider(1, keys))
+	require.Nil(t, err)
+	for i := 0; i < 2500; i++ {
+		key := fmt.Sprintf("key%d", i)
+		old.Put([]byte(key), []byte(key))
+	}
+
+	s, err := NewEncrypted(db, NewStaticKeyProvider(2, keys))
+	require.Nil(t, err)
+	s.Put([]byte("key0"), []byte("key0"))
+	// values of other users of the db
+	db.Put([]byte("raw"), []byte("raw"))
+	db.Put([]byte("foreign"), []byte{byte(CipherAESGCM), 0, 0, 0, 99, 1, 2, 3})
+	n, skipped, err := s.Rotate()
+	require.Nil(t, err)
+	assert.EqualValues(t, 2499, n)
+	assert.EqualValues(t, 2, skipped)
+	n, skipped, err = s.Rotate()
+	require.Nil(t, err)
+	assert.EqualValues(t, 0, n)
+	assert.EqualValues(t, 2, skipped)
+	assert.EqualValues(t, []byte("raw"), db.Get([]byte("raw")))
+
+	// a value sealed by a known key failing to decrypt is not skipped
+	old.Put([]byte("tampered"), []byte("value"))
+	tampered := db.Get([]byte("tampered"))
+	tampered[len(tampered)-1] ^= 0x01
+	db.Put([]byte("tampered"), tampered)
+	_, _, err = s.Rotate()
+	assert.ErrorContains(t, err, "failed to decrypt")
+	db.Delete([]byte("tampered"))
+
+	// the old key can be dropped after rotation
+	delete(keys, 1)
+	for i := 0; i < 2500; i++ {
+		key := fmt.Sprintf("key%d", i)
+		assert.EqualValues(t, []byte(key), s.Get([]byte(key)))
+	}
+}
+
+func TestEncrypted_RotateConcurrentWrites(t *testing.T) {
+	db := NewMemory()
+	keys := testKeys()
+	old, err := NewEncrypted(db, NewStaticKeyProvider(1, keys))
+	require.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		old.Put([]byte(key), []byte("old"))
+	}
+
+	s, err := NewEncrypted(db, NewStaticKeyProvider(2, keys))
+	require.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5000; i++ {
+			s.Put([]byte(fmt.Sprintf("key%d", i)), []byte("new"))
+		}
+	}()
+	_, _, err = s.Rotate()
+	require.Nil(t, err)
+	<-done
+
+	// no write is overwritten by the rotation
+	for i := 0; i < 5000; i++ {
+		require.EqualValues(t, []byte("new"), s.Get([]byte(fmt.Sprintf("key%d", i))))
+	}
+}
+
+func TestEncrypted_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		s, err := NewEncrypted(NewMemory(), NewStaticKeyProvider(1, testKeys()))
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func TestEncrypted_BenchSuite(t *testing.T) {
+	SmokeBenchKvSuite(t, func() Storage {
+		s, err := NewEncrypted(NewMemory(), NewStaticKeyProvider(1, testKeys()))
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func BenchmarkEncryptedSuite(b *testing.B) {
+	BenchKvSuite(b, func() Storage {
+		s, err := NewEncrypted(NewMemory(), NewStaticKeyProvider(1, testKeys()))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,247 @@
+package kv
+
+import (
+	"bytes"
+	"sync"
+)
+
+const defaultFeedBufferSize = 64
+
+// Change is a committed Put or Delete.
+type Change struct {
+	Key   []byte
+	Value []byte
+
+	// Deleted is true if the key was deleted, Value is nil then.
+	Deleted bool
+}
+
+// Subscription receives the committed changes of a FeedStorage.
+type Subscription interface {
+	// Changes returns the channel of the committed changes matching the subscribed prefix,
+	// one slice per commit in commit order. The channel is closed on Unsubscribe or Close.
+	Changes() <-chan []Change
+
+	// Unsubscribe stops the delivery and releases the writers blocked by the subscription.
+	Unsubscribe()
+}
+
+// FeedStorage is a Storage publishing its committed changes to the subscribers.
+type FeedStorage interface {
+	Storage
+
+	// Subscribe returns a subscription to the changes of the keys with the given prefix,
+	// a nil prefix subscribes to every change.
+	Subscribe(prefix []byte) Subscription
+}
+
+type FeedOption func(f *feed)
+
+// WithFeedBufferSize sets the number of commits buffered per subscription, defaults to 64.
+func WithFeedBufferSize(size int) FeedOption {
+	return func(f *feed) {
+		f.bufferSize = size
+	}
+}
+
+type feed struct {
+	db         Storage
+	bufferSize int
+
+	// lock serializes the commits and their delivery, so that subscribers see the commit order
+	lock sync.Mutex
+
+	// subsLock guards subs and closed, it is never held while delivering, so that Close
+	// and Unsubscribe can release the blocked writers without waiting for lock
+	subsLock sync.Mutex
+	subs     map[*subscription]struct{}
+	closed   bool
+}
+
+// NewFeed wraps db so that every committed Put, Delete and Batch is delivered to the
+// subscribers. Commits are serialized, and a commit blocks until it is buffered by every
+// matching subscriber, so a slow subscriber applies backpressure to the writers.
+func NewFeed(db Storage, opts ...FeedOption) FeedStorage {
+	f := &feed{
+		db:         db,
+		bufferSize: defaultFeedBufferSize,
+		subs:       make(map[*subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.bufferSize < 0 {
+		f.bufferSize = 0
+	}
+	return f
+}
+
+func (f *feed) Subscribe(prefix []byte) Subscription {
+	s := &subscription{
+		feed:   f,
+		prefix: append([]byte{}, prefix...),
+		ch:     make(chan []Change, f.bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	f.subsLock.Lock()
+	defer f.subsLock.Unlock()
+	if f.closed {
+		close(s.done)
+		close(s.ch)
+		return s
+	}
+	f.subs[s] = struct{}{}
+	return s
+}
+
+// publish delivers changes to the matching subscribers, it must be called with the lock held.
+func (f *feed) publish(changes []Change) {
+	f.subsLock.Lock()
+	subs := make([]*subscription, 0, len(f.subs))
+	for s := range f.subs {
+		subs = append(subs, s)
+	}
+	f.subsLock.Unlock()
+
+	for _, s := range subs {
+		s.deliver(changes)
+	}
+}
+
+func (f *feed) Put(key, value []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.db.Put(key, value)
+	f.publish([]Change{{Key: append([]byte{}, key...), Value: append([]byte{}, value...)}})
+}
+
+func (f *feed) Delete(key []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.db.Delete(key)
+	f.publish([]Change{{Key: append([]byte{}, key...), Deleted: true}})
+}
+
+func (f *feed) Get(key []byte) []byte {
+	return f.db.Get(key)
+}
+
+func (f *feed) Has(key []byte) bool {
+	return f.db.Has(key)
+}
+
+func (f *feed) Iterator(start, end []byte) Iterator {
+	return f.db.Iterator(start, end)
+}
+
+func (f *feed) Prefix(prefix []byte) Iterator {
+	return f.db.Prefix(prefix)
+}
+
+func (f *feed) NewBatch() Batch {
+	return &feedBatch{Batch: f.db.NewBatch(), f: f}
+}
+
+// Close closes the channels of all the subscriptions and the underlying storage.
+func (f *feed) Close() error {
+	f.subsLock.Lock()
+	f.closed = true
+	subs := f.subs
+	f.subs = make(map[*subscription]struct{})
+	f.subsLock.Unlock()
+
+	// release the blocked deliveries before waiting for the lock
+	for s := range subs {
+		s.once.Do(func() {
+			close(s.done)
+		})
+	}
+
+	// no delivery is in progress while holding the lock, so the channels can be closed
+	f.lock.Lock()
+	for s := range subs {
+		close(s.ch)
+	}
+	f.lock.Unlock()
+	return f.db.Close()
+}
+
+type subscription struct {
+	feed   *feed
+	prefix []byte
+	ch     chan []Change
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *subscription) Changes() <-chan []Change {
+	return s.ch
+}
+
+// deliver blocks until the matching changes are buffered or the subscription is stopped.
+func (s *subscription) deliver(changes []Change) {
+	matched := changes
+	if len(s.prefix) > 0 {
+		matched = nil
+		for _, change := range changes {
+			if bytes.HasPrefix(change.Key, s.prefix) {
+				matched = append(matched, change)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	select {
+	case s.ch <- matched:
+	case <-s.done:
+	}
+}
+
+func (s *subscription) Unsubscribe() {
+	// release the blocked delivery before waiting for the lock
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+	s.feed.subsLock.Lock()
+	defer s.feed.subsLock.Unlock()
+	if _, ok := s.feed.subs[s]; ok {
+		delete(s.feed.subs, s)
+		close(s.ch)
+	}
+}
+
+type feedBatch struct {
+	Batch
+	f       *feed
+	changes []Change
+}
+
+func (b *feedBatch) Put(key, value []byte) {
+	b.Batch.Put(key, value)
+	b.changes = append(b.changes, Change{Key: append([]byte{}, key...), Value: append([]byte{}, value...)})
+}
+
+func (b *feedBatch) Delete(key []byte) {
+	b.Batch.Delete(key)
+	b.changes = append(b.changes, Change{Key: append([]byte{}, key...), Deleted: true})
+}
+
+func (b *feedBatch) Commit() {
+	b.f.lock.Lock()
+	defer b.f.lock.Unlock()
+	b.Batch.Commit()
+	if len(b.changes) > 0 {
+		b.f.publish(b.changes)
+	}
+}
+
+func (b *feedBatch) Reset() {
+	b.Batch.Reset()
+	b.changes = nil
+}
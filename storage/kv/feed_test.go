@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeed_Subscribe(t *testing.T) {
+	f := NewFeed(NewMemory())
+	all := f.Subscribe(nil)
+	accounts := f.Subscribe([]byte("acc-"))
+
+	f.Put([]byte("acc-1"), []byte("value"))
+	f.Delete([]byte("code-1"))
+	batch := f.NewBatch()
+	batch.Put([]byte("acc-2"), []byte("value"))
+	batch.Delete([]byte("acc-1"))
+	batch.Put([]byte("code-2"), []byte{})
+	batch.Commit()
+
+	assert.EqualValues(t, []Change{{Key: []byte("acc-1"), Value: []byte("value")}}, <-all.Changes())
+	assert.EqualValues(t, []Change{{Key: []byte("code-1"), Deleted: true}}, <-all.Changes())
+	assert.EqualValues(t, []Change{
+		{Key: []byte("acc-2"), Value: []byte("value")},
+		{Key: []byte("acc-1"), Deleted: true},
+		{Key: []byte("code-2"), Value: []byte{}},
+	}, <-all.Changes())
+
+	assert.EqualValues(t, []Change{{Key: []byte("acc-1"), Value: []byte("value")}}, <-accounts.Changes())
+	assert.EqualValues(t, []Change{
+		{Key: []byte("acc-2"), Value: []byte("value")},
+		{Key: []byte("acc-1"), Deleted: true},
+	}, <-accounts.Changes())
+	assert.Len(t, accounts.Changes(), 0)
+
+	accounts.Unsubscribe()
+	_, ok := <-accounts.Changes()
+	assert.False(t, ok)
+
+	require.Nil(t, f.Close())
+	_, ok = <-all.Changes()
+	assert.False(t, ok)
+}
+
+func TestFeed_Backpressure(t *testing.T) {
+	f := NewFeed(NewMemory(), WithFeedBufferSize(1))
+	sub := f.Subscribe(nil)
+
+	f.Put([]byte("key0"), []byte("value"))
+	committed := make(chan struct{})
+	go func() {
+		f.Put([]byte("key1"), []byte("value"))
+		close(committed)
+	}()
+	select {
+	case <-committed:
+		t.Fatal("commit should block on the full subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.EqualValues(t, "key0", (<-sub.Changes())[0].Key)
+	<-committed
+	assert.EqualValues(t, "key1", (<-sub.Changes())[0].Key)
+
+	// unsubscribing releases the blocked writers
+	f.Put([]byte("key2"), []byte("value"))
+	go func() {
+		f.Put([]byte("key3"), []byte("value"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sub.Unsubscribe()
+	require.Eventually(t, func() bool {
+		return f.Has([]byte("key3"))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFeed_CloseReleasesWriters(t *testing.T) {
+	f := NewFeed(NewMemory(), WithFeedBufferSize(1))
+	sub := f.Subscribe(nil)
+
+	f.Put([]byte("key0"), []byte("value"))
+	committed := make(chan struct{})
+	go func() {
+		f.Put([]byte("key1"), []byte("value"))
+		close(committed)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() {
+		closed <- f.Close()
+	}()
+	select {
+	case err := <-closed:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("close should release the blocked writer")
+	}
+	<-committed
+
+	assert.EqualValues(t, "key0", (<-sub.Changes())[0].Key)
+	_, ok := <-sub.Changes()
+	assert.False(t, ok)
+	sub.Unsubscribe()
+}
+
+func TestFeed_Order(t *testing.T) {
+	f := NewFeed(NewMemory())
+	sub := f.Subscribe(nil)
+
+	const writers, writes = 4, 100
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			for i := 0; i < writes; i++ {
+				f.Put([]byte(fmt.Sprintf("%d-%03d", w, i)), []byte{byte(i)})
+			}
+		}(w)
+	}
+
+	last := make(map[byte]int)
+	for i := 0; i < writers*writes; i++ {
+		change := (<-sub.Changes())[0]
+		prev, ok := last[change.Key[0]]
+		if ok {
+			require.EqualValues(t, prev+1, change.Value[0])
+		}
+		last[change.Key[0]] = int(change.Value[0])
+	}
+	sub.Unsubscribe()
+}
+
+func TestFeed_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		return NewFeed(NewMemory())
+	})
+}
@@ -0,0 +1,382 @@
+package kv
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultFilterExpectedKeys = 1 << 20
+	defaultFilterFPRate       = 0.01
+
+	// hashes(4) | bits(8) | keys(8)
+	filterHeaderSize = 20
+)
+
+var filterCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FilteredStorage is a Storage short-circuiting the lookups of missing keys by a bloom filter.
+type FilteredStorage interface {
+	Storage
+
+	// Rebuild rebuilds the filter from the keys of the storage, dropping the bits of the
+	// deleted keys. Reads and writes are not blocked during the rebuild.
+	Rebuild()
+}
+
+type FilterOption func(f *filtered)
+
+// WithFilterExpectedKeys sets the number of keys the filter is sized for, defaults to 1<<20.
+// The filter is resized on Rebuild if more keys have been added than expected.
+func WithFilterExpectedKeys(n int) FilterOption {
+	return func(f *filtered) {
+		f.expectedKeys = n
+	}
+}
+
+// WithFilterFalsePositiveRate sets the target false positive rate of the filter, defaults to 0.01.
+func WithFilterFalsePositiveRate(rate float64) FilterOption {
+	return func(f *filtered) {
+		f.fpRate = rate
+	}
+}
+
+// WithFilterMetrics reports the filter lookups, the short-circuited misses and the false positives.
+func WithFilterMetrics(registry prometheus.Registerer, name string) FilterOption {
+	return func(f *filtered) {
+		f.registry = registry
+		f.name = name
+	}
+}
+
+type filtered struct {
+	db           Storage
+	path         string
+	expectedKeys int
+	fpRate       float64
+
+	// lock guards the filters, not their bits, writes hold the read lock
+	lock       sync.RWMutex
+	filter     *bloom
+	rebuilding *bloom
+	rebuildMu  sync.Mutex
+
+	lookups        atomic.Int64
+	negatives      atomic.Int64
+	falsePositives atomic.Int64
+
+	name       string
+	registry   prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+// NewFilteredStorage wraps db so that Get and Has of the keys missing from a bloom filter
+// return without reading db. It suits backends without filters of their own, like leveldb.
+// The filter is loaded from the file at path, or rebuilt from the keys of db if the file is
+// missing or corrupted. The file is removed once loaded and written back on Close, so the
+// filter is rebuilt after a crash instead of missing the keys written since the last save.
+// An empty path keeps the filter in memory only.
+// Deleted keys stay in the filter until the next Rebuild.
+func NewFilteredStorage(db Storage, path string, opts ...FilterOption) (FilteredStorage, error) {
+	f := &filtered{
+		db:           db,
+		path:         path,
+		expectedKeys: defaultFilterExpectedKeys,
+		fpRate:       defaultFilterFPRate,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.expectedKeys <= 0 {
+		f.expectedKeys = defaultFilterExpectedKeys
+	}
+	if f.fpRate <= 0 || f.fpRate >= 1 {
+		f.fpRate = defaultFilterFPRate
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			f.filter = decodeBloom(data)
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if f.filter == nil {
+		f.filter = newBloom(f.expectedKeys, f.fpRate)
+		it := db.Iterator(nil, nil)
+		for it.Next() {
+			f.filter.add(it.Key())
+		}
+	}
+
+	if f.registry != nil {
+		labels := prometheus.Labels{"db": f.name}
+		f.collectors = []prometheus.Collector{
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "kv_filter_lookups_total",
+				Help:        "number of Get and Has checked against the filter",
+				ConstLabels: labels,
+			}, func() float64 { return float64(f.lookups.Load()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "kv_filter_negatives_total",
+				Help:        "number of lookups short-circuited by the filter",
+				ConstLabels: labels,
+			}, func() float64 { return float64(f.negatives.Load()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "kv_filter_false_positives_total",
+				Help:        "number of lookups passing the filter for missing keys",
+				ConstLabels: labels,
+			}, func() float64 { return float64(f.falsePositives.Load()) }),
+		}
+		if err := registerCollectors(f.registry, f.collectors...); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// write adds the keys to the filters before the keys are written to db, so that a reader
+// never misses a written key. The read lock is held during the write, so that Rebuild
+// never starts in the middle of a write and misses its keys.
+func (f *filtered) write(write func(), keys ...[]byte) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, key := range keys {
+		f.filter.add(key)
+		if f.rebuilding != nil {
+			// counted by the scan, so that a key seen by the scan is not counted twice
+			f.rebuilding.setBits(key)
+		}
+	}
+	write()
+}
+
+func (f *filtered) mayContain(key []byte) bool {
+	f.lookups.Add(1)
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if !f.filter.mayContain(key) {
+		f.negatives.Add(1)
+		return false
+	}
+	return true
+}
+
+func (f *filtered) Put(key, value []byte) {
+	f.write(func() {
+		f.db.Put(key, value)
+	}, key)
+}
+
+func (f *filtered) Delete(key []byte) {
+	f.db.Delete(key)
+}
+
+func (f *filtered) Get(key []byte) []byte {
+	if !f.mayContain(key) {
+		return nil
+	}
+	value := f.db.Get(key)
+	if value == nil {
+		f.falsePositives.Add(1)
+	}
+	return value
+}
+
+func (f *filtered) Has(key []byte) bool {
+	if !f.mayContain(key) {
+		return false
+	}
+	has := f.db.Has(key)
+	if !has {
+		f.falsePositives.Add(1)
+	}
+	return has
+}
+
+func (f *filtered) Iterator(start, end []byte) Iterator {
+	return f.db.Iterator(start, end)
+}
+
+func (f *filtered) Prefix(prefix []byte) Iterator {
+	return f.db.Prefix(prefix)
+}
+
+func (f *filtered) NewBatch() Batch {
+	return &filteredBatch{Batch: f.db.NewBatch(), f: f}
+}
+
+func (f *filtered) Rebuild() {
+	f.rebuildMu.Lock()
+	defer f.rebuildMu.Unlock()
+
+	f.lock.Lock()
+	expectedKeys := f.expectedKeys
+	if keys := int(f.filter.keys.Load()); keys > expectedKeys {
+		expectedKeys = keys
+	}
+	// the keys written during the scan are added to both filters
+	f.rebuilding = newBloom(expectedKeys, f.fpRate)
+	f.lock.Unlock()
+
+	it := f.db.Iterator(nil, nil)
+	for it.Next() {
+		f.rebuilding.add(it.Key())
+	}
+
+	f.lock.Lock()
+	f.filter, f.rebuilding = f.rebuilding, nil
+	f.lock.Unlock()
+}
+
+// Close writes the filter to its file and closes db, db is closed even if the write fails.
+func (f *filtered) Close() error {
+	unregisterCollectors(f.registry, f.collectors...)
+	var err error
+	if f.path != "" {
+		err = f.save()
+	}
+	if closeErr := f.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *filtered) save() error {
+	f.lock.RLock()
+	data := f.filter.encode()
+	f.lock.RUnlock()
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+type filteredBatch struct {
+	Batch
+	f    *filtered
+	keys [][]byte
+}
+
+func (b *filteredBatch) Put(key, value []byte) {
+	b.Batch.Put(key, value)
+	b.keys = append(b.keys, append([]byte{}, key...))
+}
+
+func (b *filteredBatch) Commit() {
+	b.f.write(b.Batch.Commit, b.keys...)
+}
+
+func (b *filteredBatch) Reset() {
+	b.Batch.Reset()
+	b.keys = nil
+}
+
+// bloom is a bloom filter safe for concurrent use, its bits are set atomically.
+type bloom struct {
+	hashes uint32
+	bits   []uint64
+	keys   atomic.Int64
+}
+
+func newBloom(n int, fpRate float64) *bloom {
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	hashes := uint32(math.Round(m / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &bloom{
+		hashes: hashes,
+		bits:   make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+// locations returns the hash pair for double hashing.
+func (b *bloom) locations(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	return sum, sum>>32 | 1
+}
+
+func (b *bloom) add(key []byte) {
+	b.setBits(key)
+	b.keys.Add(1)
+}
+
+// setBits sets the bits of key without counting it.
+func (b *bloom) setBits(key []byte) {
+	h1, h2 := b.locations(key)
+	m := uint64(len(b.bits)) * 64
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		word, mask := &b.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+func (b *bloom) mayContain(key []byte) bool {
+	h1, h2 := b.locations(key)
+	m := uint64(len(b.bits)) * 64
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if atomic.LoadUint64(&b.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode encodes the filter as header | bits | crc32c(4).
+func (b *bloom) encode() []byte {
+	data := make([]byte, filterHeaderSize+8*len(b.bits)+4)
+	binary.BigEndian.PutUint32(data[0:4], b.hashes)
+	binary.BigEndian.PutUint64(data[4:12], uint64(len(b.bits)))
+	binary.BigEndian.PutUint64(data[12:20], uint64(b.keys.Load()))
+	for i := range b.bits {
+		binary.BigEndian.PutUint64(data[filterHeaderSize+8*i:], atomic.LoadUint64(&b.bits[i]))
+	}
+	crcOffset := len(data) - 4
+	binary.BigEndian.PutUint32(data[crcOffset:], crc32.Checksum(data[:crcOffset], filterCRCTable))
+	return data
+}
+
+// decodeBloom returns the encoded filter, or nil if data is corrupted.
+func decodeBloom(data []byte) *bloom {
+	if len(data) < filterHeaderSize+4 {
+		return nil
+	}
+	crcOffset := len(data) - 4
+	if crc32.Checksum(data[:crcOffset], filterCRCTable) != binary.BigEndian.Uint32(data[crcOffset:]) {
+		return nil
+	}
+	b := &bloom{hashes: binary.BigEndian.Uint32(data[0:4])}
+	words := binary.BigEndian.Uint64(data[4:12])
+	if b.hashes == 0 || words == 0 || uint64(crcOffset-filterHeaderSize) != 8*words {
+		return nil
+	}
+	b.keys.Store(int64(binary.BigEndian.Uint64(data[12:20])))
+	b.bits = make([]uint64, words)
+	for i := range b.bits {
+		b.bits[i] = binary.BigEndian.Uint64(data[filterHeaderSize+8*i:])
+	}
+	return b
+}
@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilteredStorage(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	db := NewMemory()
+	db.Put([]byte("existing"), []byte("value"))
+	f, err := NewFilteredStorage(db, "", WithFilterExpectedKeys(1000), WithFilterMetrics(registry, "test"))
+	require.Nil(t, err)
+
+	assert.True(t, f.Has([]byte("existing")))
+	for i := 0; i < 1000; i++ {
+		f.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	batch := f.NewBatch()
+	batch.Put([]byte("batched"), []byte("value"))
+	assert.False(t, f.Has([]byte("batched")))
+	batch.Commit()
+	assert.EqualValues(t, []byte("value"), f.Get([]byte("batched")))
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.Has([]byte(fmt.Sprintf("key%d", i))))
+		assert.False(t, f.Has([]byte(fmt.Sprintf("missing%d", i))))
+	}
+
+	fpRate := testutil.ToFloat64(f.(*filtered).collectors[2]) / 1000
+	assert.Less(t, fpRate, 0.05)
+	assert.EqualValues(t, 2003, testutil.ToFloat64(f.(*filtered).collectors[0]))
+	assert.EqualValues(t, 1001-fpRate*1000, testutil.ToFloat64(f.(*filtered).collectors[1]))
+
+	require.Nil(t, f.Close())
+	count, err := testutil.GatherAndCount(registry)
+	require.Nil(t, err)
+	assert.Zero(t, count)
+}
+
+func TestFilteredStorage_Rebuild(t *testing.T) {
+	f, err := NewFilteredStorage(NewMemory(), "", WithFilterExpectedKeys(100))
+	require.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		f.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	for i := 0; i < 900; i++ {
+		f.Delete([]byte(fmt.Sprintf("key%d", i)))
+	}
+
+	overloaded := f.(*filtered).filter
+	assert.EqualValues(t, 1000, overloaded.keys.Load())
+	f.Rebuild()
+	rebuilt := f.(*filtered).filter
+	assert.Greater(t, len(rebuilt.bits), len(overloaded.bits))
+	assert.EqualValues(t, 100, rebuilt.keys.Load())
+
+	// a key written during the scan is counted by the scan only
+	rebuilding := newBloom(100, defaultFilterFPRate)
+	f.(*filtered).rebuilding = rebuilding
+	f.Put([]byte("rebuilding"), []byte("value"))
+	f.(*filtered).rebuilding = nil
+	assert.True(t, rebuilding.mayContain([]byte("rebuilding")))
+	assert.Zero(t, rebuilding.keys.Load())
+	assert.EqualValues(t, 101, rebuilt.keys.Load())
+	for i := 900; i < 1000; i++ {
+		assert.True(t, f.Has([]byte(fmt.Sprintf("key%d", i))))
+	}
+
+	var overloadedFP, rebuiltFP int
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("missing%d", i))
+		if overloaded.mayContain(key) {
+			overloadedFP++
+		}
+		if rebuilt.mayContain(key) {
+			rebuiltFP++
+		}
+	}
+	assert.Less(t, rebuiltFP, overloadedFP)
+}
+
+// unclosable keeps the memory storage alive across reopens of its wrapper.
+type unclosable struct {
+	Storage
+}
+
+func (unclosable) Close() error {
+	return nil
+}
+
+func TestFilteredStorage_Persist(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filter")
+	db := unclosable{NewMemory()}
+
+	f, err := NewFilteredStorage(db, path)
+	require.Nil(t, err)
+	f.Put([]byte("key"), []byte("value"))
+	require.Nil(t, f.Close())
+	_, err = os.Stat(path)
+	require.Nil(t, err)
+
+	// the saved filter is loaded instead of rebuilt
+	db.Put([]byte("unfiltered"), []byte("value"))
+	f, err = NewFilteredStorage(db, path)
+	require.Nil(t, err)
+	assert.True(t, f.Has([]byte("key")))
+	assert.False(t, f.Has([]byte("unfiltered")))
+
+	// the file is removed while open, so a crash leads to a rebuild
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	f, err = NewFilteredStorage(db, path)
+	require.Nil(t, err)
+	assert.True(t, f.Has([]byte("unfiltered")))
+
+	// a corrupted filter is rebuilt
+	require.Nil(t, os.WriteFile(path, []byte("corrupted filter"), 0644))
+	f, err = NewFilteredStorage(db, path)
+	require.Nil(t, err)
+	assert.True(t, f.Has([]byte("key")))
+	assert.True(t, f.Has([]byte("unfiltered")))
+}
+
+// closeRecorder records whether the storage has been closed.
+type closeRecorder struct {
+	Storage
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.Storage.Close()
+}
+
+func TestFilteredStorage_CloseWriteFailure(t *testing.T) {
+	db := &closeRecorder{Storage: NewMemory()}
+	f, err := NewFilteredStorage(db, filepath.Join(t.TempDir(), "missing", "filter"))
+	require.Nil(t, err)
+	assert.NotNil(t, f.Close())
+	assert.True(t, db.closed)
+}
+
+func TestFilteredStorage_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		f, err := NewFilteredStorage(NewMemory(), "")
+		require.Nil(t, err)
+		return f
+	})
+}
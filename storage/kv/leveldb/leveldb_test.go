@@ -1,6 +1,7 @@
 package leveldb
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"os"
@@ -436,3 +437,24 @@ func BenchmarkLevelDbSuite(b *testing.B) {
 		return db
 	})
 }
+
+func TestLdb_Conformance(t *testing.T) {
+	kv.ConformanceSuite(t, func() kv.Storage {
+		s, err := New(t.TempDir(), nil)
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func TestLdb_Encrypted(t *testing.T) {
+	keys := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}
+	newEncrypted := func() kv.Storage {
+		db, err := New(t.TempDir(), nil)
+		require.Nil(t, err)
+		s, err := kv.NewEncrypted(db, kv.NewStaticKeyProvider(1, keys))
+		require.Nil(t, err)
+		return s
+	}
+	kv.ConformanceSuite(t, newEncrypted)
+	kv.SmokeBenchKvSuite(t, newEncrypted)
+}
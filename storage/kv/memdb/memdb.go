@@ -0,0 +1,506 @@
+package memdb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/fileutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/axiomesh/axiom-kit/log"
+	"github.com/axiomesh/axiom-kit/storage/kv"
+)
+
+const (
+	walFileName      = "WAL"
+	snapshotFileName = "SNAPSHOT"
+
+	snapshotRecordSize = 1000
+)
+
+type Options struct {
+	// SnapshotInterval is the interval of the periodic snapshot which compacts the WAL,
+	// 0 disables the periodic snapshot.
+	SnapshotInterval time.Duration
+
+	// SyncWrites fsyncs the WAL after every write.
+	SyncWrites bool
+
+	Logger logrus.FieldLogger
+}
+
+var errClosed = errors.New("memdb is closed")
+
+type memdb struct {
+	lock   sync.RWMutex
+	list   *skiplist
+	closed bool
+
+	path         string
+	opts         Options
+	wal          *os.File
+	instanceLock fileutil.Releaser
+
+	closeOnce sync.Once
+	closeC    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New returns a skiplist based in-memory storage.
+// If path is not empty, every write is appended to a WAL under path and the data
+// is recovered on the next open, the WAL is compacted by the periodic snapshot.
+func New(path string, opts *Options) (kv.Storage, error) {
+	db := &memdb{
+		list:   newSkiplist(),
+		path:   path,
+		closeC: make(chan struct{}),
+	}
+	if opts != nil {
+		db.opts = *opts
+	}
+	if db.opts.Logger == nil {
+		db.opts.Logger = log.NewWithModule("memdb")
+	}
+	if path == "" {
+		return db, nil
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	lock, _, err := fileutil.Flock(filepath.Join(path, "FLOCK"))
+	if err != nil {
+		return nil, err
+	}
+	db.instanceLock = lock
+	if err := db.recover(); err != nil {
+		_ = lock.Release()
+		return nil, err
+	}
+
+	if db.opts.SnapshotInterval > 0 {
+		db.wg.Add(1)
+		go db.snapshotLoop()
+	}
+	return db, nil
+}
+
+// recover loads the snapshot and replays the WAL, a torn WAL tail is truncated
+// but a corrupted record in the middle of the WAL fails the recovery.
+func (db *memdb) recover() error {
+	apply := func(ops []op) {
+		db.apply(ops)
+	}
+
+	if snapshot, err := os.Open(filepath.Join(db.path, snapshotFileName)); err == nil {
+		info, err := snapshot.Stat()
+		if err != nil {
+			_ = snapshot.Close()
+			return err
+		}
+		size, err := replay(snapshot, info.Size(), apply)
+		_ = snapshot.Close()
+		if err != nil {
+			return err
+		}
+		if size != info.Size() {
+			return errors.Errorf("corrupted memdb snapshot at offset %d", size)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(db.path, walFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := wal.Stat()
+	if err != nil {
+		_ = wal.Close()
+		return err
+	}
+	size, err := replay(wal, info.Size(), apply)
+	if err != nil {
+		_ = wal.Close()
+		return err
+	}
+	if size != info.Size() {
+		db.opts.Logger.WithFields(logrus.Fields{
+			"size":  info.Size(),
+			"valid": size,
+		}).Warn("Truncate torn memdb WAL tail")
+		if err := wal.Truncate(size); err != nil {
+			_ = wal.Close()
+			return err
+		}
+	}
+	if _, err := wal.Seek(size, 0); err != nil {
+		_ = wal.Close()
+		return err
+	}
+	db.wal = wal
+	return nil
+}
+
+func (db *memdb) snapshotLoop() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.opts.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closeC:
+			return
+		case <-ticker.C:
+			if err := db.snapshot(); err != nil {
+				db.opts.Logger.WithField("err", err).Error("Failed to snapshot memdb")
+			}
+		}
+	}
+}
+
+// snapshot writes all the data to the snapshot file and drops the WAL records it holds.
+// The writes are only blocked while the data is copied, the WAL records written while
+// the snapshot file is written are moved to a new WAL.
+func (db *memdb) snapshot() error {
+	if db.wal == nil {
+		return nil
+	}
+	db.lock.RLock()
+	var ops []op
+	for n := db.list.head.next[0]; n != nil; n = n.next[0] {
+		// the nodes are never modified in place, so their slices can be shared
+		ops = append(ops, op{kind: opPut, key: n.key, value: n.value})
+	}
+	info, err := db.wal.Stat()
+	db.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	snapshotted := info.Size()
+
+	tmp := filepath.Join(db.path, snapshotFileName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > snapshotRecordSize {
+			n = snapshotRecordSize
+		}
+		if _, err := f.Write(encodeRecord(ops[:n])); err != nil {
+			_ = f.Close()
+			return err
+		}
+		ops = ops[n:]
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(db.path, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(db.path); err != nil {
+		return err
+	}
+
+	// replaying the WAL on top of the new snapshot is harmless,
+	// so a crash before the WAL is replaced loses nothing
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.dropWAL(snapshotted)
+}
+
+// dropWAL drops the first size bytes of the WAL, the lock must be held.
+func (db *memdb) dropWAL(size int64) error {
+	info, err := db.wal.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == size {
+		if err := db.wal.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := db.wal.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return db.wal.Sync()
+	}
+
+	rest := make([]byte, info.Size()-size)
+	if _, err := db.wal.ReadAt(rest, size); err != nil {
+		return err
+	}
+	path := filepath.Join(db.path, walFileName)
+	f, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(rest); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		_ = f.Close()
+		return err
+	}
+	_ = db.wal.Close()
+	db.wal = f
+	return syncDir(db.path)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// write appends ops to the WAL and applies them to the skiplist.
+func (db *memdb) write(ops []op) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.closed {
+		panic(errClosed)
+	}
+
+	if db.wal != nil {
+		if _, err := db.wal.Write(encodeRecord(ops)); err != nil {
+			panic(errors.Wrap(err, "failed to write memdb WAL"))
+		}
+		if db.opts.SyncWrites {
+			if err := db.wal.Sync(); err != nil {
+				panic(errors.Wrap(err, "failed to sync memdb WAL"))
+			}
+		}
+	}
+	db.apply(ops)
+}
+
+func (db *memdb) apply(ops []op) {
+	for _, o := range ops {
+		if o.kind == opPut {
+			db.list.put(o.key, o.value)
+		} else {
+			db.list.delete(o.key)
+		}
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+func (db *memdb) Put(key, value []byte) {
+	db.write([]op{{kind: opPut, key: copyBytes(key), value: copyBytes(value)}})
+}
+
+func (db *memdb) Delete(key []byte) {
+	db.write([]op{{kind: opDelete, key: copyBytes(key)}})
+}
+
+func (db *memdb) Get(key []byte) []byte {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		panic(errClosed)
+	}
+	if value, ok := db.list.get(key); ok {
+		return copyBytes(value)
+	}
+	return nil
+}
+
+func (db *memdb) Has(key []byte) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		panic(errClosed)
+	}
+	_, ok := db.list.get(key)
+	return ok
+}
+
+func (db *memdb) Iterator(start, end []byte) kv.Iterator {
+	return &iter{
+		db:    db,
+		start: copyBytes(start),
+		end:   bytes.Clone(end),
+	}
+}
+
+func (db *memdb) Prefix(prefix []byte) kv.Iterator {
+	return &iter{
+		db:    db,
+		start: copyBytes(prefix),
+		end:   prefixEnd(prefix),
+	}
+}
+
+// prefixEnd returns the smallest key greater than all the keys with the given prefix,
+// or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := copyBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (db *memdb) NewBatch() kv.Batch {
+	return &batch{db: db}
+}
+
+func (db *memdb) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		close(db.closeC)
+		db.wg.Wait()
+		db.lock.Lock()
+		defer db.lock.Unlock()
+		db.closed = true
+		if db.wal != nil {
+			// the file lock is released even if the WAL fails to sync or close
+			err = db.wal.Sync()
+			if closeErr := db.wal.Close(); err == nil {
+				err = closeErr
+			}
+			if releaseErr := db.instanceLock.Release(); err == nil {
+				err = releaseErr
+			}
+		}
+	})
+	return err
+}
+
+type iterDirection int
+
+const (
+	dirSOI iterDirection = iota
+	dirEOI
+	dirValid
+)
+
+// iter re-seeks the skiplist by the current key on every move,
+// so it is never invalidated by concurrent writes.
+// Key and Value return copies, the slices of the nodes are never exposed.
+type iter struct {
+	db         *memdb
+	start, end []byte
+
+	dir   iterDirection
+	key   []byte
+	value []byte
+}
+
+func (it *iter) inRange(n *node) bool {
+	return n != nil && bytes.Compare(n.key, it.start) >= 0 && (it.end == nil || bytes.Compare(n.key, it.end) < 0)
+}
+
+// set positions the iterator at n, or exhausts it in the given direction.
+func (it *iter) set(n *node, exhausted iterDirection) bool {
+	if !it.inRange(n) {
+		it.dir, it.key, it.value = exhausted, nil, nil
+		return false
+	}
+	it.dir, it.key, it.value = dirValid, n.key, n.value
+	return true
+}
+
+func (it *iter) Next() bool {
+	it.db.lock.RLock()
+	defer it.db.lock.RUnlock()
+	switch it.dir {
+	case dirEOI:
+		return false
+	case dirSOI:
+		return it.set(it.db.list.findGE(it.start, nil), dirEOI)
+	default:
+		return it.set(it.db.list.findGE(append(copyBytes(it.key), 0), nil), dirEOI)
+	}
+}
+
+func (it *iter) Prev() bool {
+	it.db.lock.RLock()
+	defer it.db.lock.RUnlock()
+	switch it.dir {
+	case dirSOI:
+		return false
+	case dirEOI:
+		if it.end == nil {
+			return it.set(it.db.list.findLast(), dirSOI)
+		}
+		return it.set(it.db.list.findLT(it.end), dirSOI)
+	default:
+		return it.set(it.db.list.findLT(it.key), dirSOI)
+	}
+}
+
+func (it *iter) Seek(key []byte) bool {
+	it.db.lock.RLock()
+	defer it.db.lock.RUnlock()
+	if bytes.Compare(key, it.start) < 0 {
+		key = it.start
+	}
+	return it.set(it.db.list.findGE(key, nil), dirEOI)
+}
+
+func (it *iter) Key() []byte {
+	return bytes.Clone(it.key)
+}
+
+func (it *iter) Value() []byte {
+	return bytes.Clone(it.value)
+}
+
+type batch struct {
+	db   *memdb
+	ops  []op
+	size int
+}
+
+func (b *batch) Put(key, value []byte) {
+	b.ops = append(b.ops, op{kind: opPut, key: copyBytes(key), value: copyBytes(value)})
+	b.size += len(key) + len(value)
+}
+
+func (b *batch) Delete(key []byte) {
+	b.ops = append(b.ops, op{kind: opDelete, key: copyBytes(key)})
+	b.size += len(key)
+}
+
+func (b *batch) Commit() {
+	if len(b.ops) == 0 {
+		return
+	}
+	b.db.write(b.ops)
+}
+
+func (b *batch) Size() int {
+	return b.size
+}
+
+func (b *batch) Reset() {
+	b.ops = nil
+	b.size = 0
+}
@@ -0,0 +1,460 @@
+package memdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axiomesh/axiom-kit/storage/kv"
+)
+
+func TestMemdb_Conformance(t *testing.T) {
+	kv.ConformanceSuite(t, func() kv.Storage {
+		s, err := New("", nil)
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func TestMemdb_ConformanceWithWAL(t *testing.T) {
+	kv.ConformanceSuite(t, func() kv.Storage {
+		s, err := New(t.TempDir(), nil)
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func TestMemdb_Lock(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, nil)
+	require.Nil(t, err)
+	_, err = New(dir, nil)
+	require.NotNil(t, err)
+	require.Nil(t, s.Close())
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Recover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, &Options{SyncWrites: true})
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Commit()
+	s.Delete([]byte("key5"))
+	s.Put([]byte("key6"), []byte("updated"))
+	s.Put([]byte("empty"), nil)
+	require.Nil(t, s.Close())
+
+	check := func(s kv.Storage) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%d", i)
+			switch i {
+			case 5:
+				assert.Nil(t, s.Get([]byte(key)))
+			case 6:
+				assert.EqualValues(t, []byte("updated"), s.Get([]byte(key)))
+			default:
+				assert.EqualValues(t, []byte(key), s.Get([]byte(key)))
+			}
+		}
+		assert.True(t, s.Has([]byte("empty")))
+	}
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	check(s)
+
+	// snapshot compacts the WAL
+	require.Nil(t, s.(*memdb).snapshot())
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	require.Nil(t, err)
+	assert.EqualValues(t, 0, info.Size())
+	s.Put([]byte("after"), []byte("snapshot"))
+	require.Nil(t, s.Close())
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	check(s)
+	assert.EqualValues(t, []byte("snapshot"), s.Get([]byte("after")))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_TornWAL(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, nil)
+	require.Nil(t, err)
+	s.Put([]byte("a"), []byte("a"))
+	s.Put([]byte("b"), []byte("b"))
+	require.Nil(t, s.Close())
+
+	walPath := filepath.Join(dir, walFileName)
+	info, err := os.Stat(walPath)
+	require.Nil(t, err)
+	// cut the last record in the middle
+	require.Nil(t, os.Truncate(walPath, info.Size()-1))
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	assert.EqualValues(t, []byte("a"), s.Get([]byte("a")))
+	assert.Nil(t, s.Get([]byte("b")))
+	s.Put([]byte("c"), []byte("c"))
+	require.Nil(t, s.Close())
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	assert.EqualValues(t, []byte("a"), s.Get([]byte("a")))
+	assert.EqualValues(t, []byte("c"), s.Get([]byte("c")))
+	require.Nil(t, s.Close())
+
+	// a corrupted record followed by other records is not a torn tail
+	data, err := os.ReadFile(walPath)
+	require.Nil(t, err)
+	data[recordHeaderSize] ^= 0xff
+	require.Nil(t, os.WriteFile(walPath, data, 0644))
+	_, err = New(dir, nil)
+	assert.ErrorContains(t, err, "checksum mismatch at offset 0")
+	info, err = os.Stat(walPath)
+	require.Nil(t, err)
+	assert.EqualValues(t, len(data), info.Size())
+}
+
+func TestMemdb_CloseReleasesLock(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, nil)
+	require.Nil(t, err)
+	// the WAL fails to sync
+	require.Nil(t, s.(*memdb).wal.Close())
+	require.NotNil(t, s.Close())
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_PeriodicSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, &Options{SnapshotInterval: 10 * time.Millisecond})
+	require.Nil(t, err)
+	s.Put([]byte("key"), []byte("value"))
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(filepath.Join(dir, snapshotFileName))
+		return err == nil && info.Size() > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, s.Close())
+
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	assert.EqualValues(t, []byte("value"), s.Get([]byte("key")))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Put(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	s.Put([]byte("key"), []byte("value"))
+	err = s.Close()
+	require.Nil(t, err)
+}
+
+func TestMemdb_Delete(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	s.Put([]byte("key"), []byte("value"))
+	s.Delete([]byte("key"))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Get(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	s.Put([]byte("key"), []byte("value"))
+	v1 := s.Get([]byte("key"))
+	assert.Equal(t, v1, []byte("value"))
+	s.Delete([]byte("key"))
+	v2 := s.Get([]byte("key"))
+	assert.True(t, v2 == nil)
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_UseAfterClose(t *testing.T) {
+	for _, path := range []string{"", t.TempDir()} {
+		s, err := New(path, nil)
+		require.Nil(t, err)
+		batch := s.NewBatch()
+		batch.Put([]byte("key"), []byte("key"))
+		require.Nil(t, s.Close())
+
+		assert.PanicsWithError(t, errClosed.Error(), func() { s.Get([]byte("key")) })
+		assert.PanicsWithError(t, errClosed.Error(), func() { s.Has([]byte("key")) })
+		assert.PanicsWithError(t, errClosed.Error(), func() { s.Put([]byte("key"), []byte("key")) })
+		assert.PanicsWithError(t, errClosed.Error(), func() { s.Delete([]byte("key")) })
+		assert.PanicsWithError(t, errClosed.Error(), func() { batch.Commit() })
+	}
+}
+
+func TestMemdb_Has(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	key := []byte("key")
+	r1 := s.Has(key)
+	assert.True(t, !r1)
+	s.Put(key, []byte("value"))
+	r2 := s.Has(key)
+	assert.True(t, r2)
+	s.Delete(key)
+	r3 := s.Has(key)
+	assert.True(t, !r3)
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_NewBatch(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 11; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Delete([]byte("key10"))
+	batch.Commit()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		value := s.Get([]byte(key))
+		assert.EqualValues(t, key, value)
+	}
+	assert.Nil(t, s.Get([]byte("key10")))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_BatchSize(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	total := 0
+	for i := 0; i < 11; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+		total += 2 * len([]byte(key))
+	}
+	deleteKey := "key10"
+	batch.Delete([]byte(deleteKey))
+	total += len([]byte(deleteKey))
+	assert.EqualValues(t, total, batch.Size())
+	batch.Commit()
+
+	for i := 0; i < 11; i++ {
+		key := fmt.Sprintf("key%d", i)
+		value := s.Get([]byte(key))
+		if key == deleteKey {
+			assert.Nil(t, value)
+		} else {
+			assert.EqualValues(t, key, value)
+		}
+	}
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_BatchReset(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 11; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	deleteKey := "key10"
+	batch.Delete([]byte(deleteKey))
+	batch.Commit()
+
+	batch.Reset()
+	assert.EqualValues(t, 0, batch.Size())
+	total := 0
+	for i := 11; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+		total += 2 * len([]byte(key))
+	}
+	assert.EqualValues(t, total, batch.Size())
+	batch.Commit()
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		value := s.Get([]byte(key))
+		if key == deleteKey {
+			assert.Nil(t, value)
+		} else {
+			assert.EqualValues(t, key, value)
+		}
+	}
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Iterator(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Commit()
+
+	iter := s.Iterator([]byte("key0"), []byte("key9"))
+	i := 0
+	for iter.Next() {
+		assert.EqualValues(t, []byte(fmt.Sprintf("key%d", i)), iter.Value())
+		assert.EqualValues(t, []byte(fmt.Sprintf("key%d", i)), iter.Key())
+		i++
+	}
+	assert.EqualValues(t, i, 9)
+
+	// modifying the returned slices leaves the data untouched
+	iter = s.Iterator(nil, nil)
+	require.True(t, iter.Next())
+	iter.Key()[0] = 'x'
+	iter.Value()[0] = 'x'
+	assert.EqualValues(t, []byte("key0"), iter.Key())
+	assert.EqualValues(t, []byte("key0"), s.Get([]byte("key0")))
+	require.True(t, iter.Next())
+	assert.EqualValues(t, []byte("key1"), iter.Key())
+
+	iter = s.Iterator([]byte("none"), []byte("no"))
+	assert.False(t, iter.Next())
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Prefix(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 15; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Delete([]byte("key11"))
+	batch.Commit()
+
+	iter := s.Prefix([]byte("key1"))
+	expected := []string{"key1", "key10", "key12", "key13", "key14"}
+	i := 0
+	for iter.Next() {
+		assert.EqualValues(t, []byte(expected[i]), iter.Value())
+		assert.EqualValues(t, []byte(expected[i]), iter.Key())
+		i++
+	}
+	assert.EqualValues(t, i, len(expected))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Seek(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Delete([]byte("key5"))
+	batch.Commit()
+
+	iter := s.Iterator([]byte("key0"), []byte("key9"))
+	assert.True(t, iter.Seek([]byte("key5")))
+	expected := []string{"key7", "key8"}
+	i := 0
+	for iter.Next() {
+		assert.EqualValues(t, []byte(expected[i]), iter.Value())
+		assert.EqualValues(t, []byte(expected[i]), iter.Key())
+		i++
+	}
+	assert.EqualValues(t, i, len(expected))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_Prev(t *testing.T) {
+	s, err := New(t.TempDir(), nil)
+	require.Nil(t, err)
+
+	batch := s.NewBatch()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Delete([]byte("key3"))
+	batch.Commit()
+
+	iter := s.Iterator([]byte("key0"), []byte("key9"))
+	iter.Seek([]byte("key6"))
+	expected := []string{"key5", "key4", "key2", "key1", "key0"}
+	i := 0
+	for iter.Prev() {
+		assert.EqualValues(t, []byte(expected[i]), iter.Value())
+		assert.EqualValues(t, []byte(expected[i]), iter.Key())
+		i++
+	}
+	assert.EqualValues(t, i, len(expected))
+	require.Nil(t, s.Close())
+}
+
+func TestMemdb_SnapshotConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, nil)
+	require.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		s.Put([]byte(key), []byte(key))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("write%d", i)
+			s.Put([]byte(key), []byte(key))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.Nil(t, s.(*memdb).snapshot())
+	}
+	<-done
+	require.Nil(t, s.Close())
+
+	// the writes during the snapshots are kept in the WAL
+	s, err = New(dir, nil)
+	require.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.EqualValues(t, []byte(fmt.Sprintf("key%d", i)), s.Get([]byte(fmt.Sprintf("key%d", i))))
+		assert.EqualValues(t, []byte(fmt.Sprintf("write%d", i)), s.Get([]byte(fmt.Sprintf("write%d", i))))
+	}
+	require.Nil(t, s.Close())
+}
+
+func BenchmarkMemdbSuite(b *testing.B) {
+	kv.BenchKvSuite(b, func() kv.Storage {
+		s, err := New("", nil)
+		require.Nil(b, err)
+		return s
+	})
+}
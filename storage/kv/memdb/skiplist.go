@@ -0,0 +1,130 @@
+package memdb
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+const (
+	maxHeight = 12
+	branching = 4
+)
+
+type node struct {
+	key   []byte
+	value []byte
+	next  [maxHeight]*node
+}
+
+// skiplist is an ordered map of byte slices, it is not safe for concurrent use.
+type skiplist struct {
+	head   node
+	height int
+	rnd    *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		height: 1,
+		rnd:    rand.New(rand.NewSource(0xdeadbeef)),
+	}
+}
+
+func (s *skiplist) randomHeight() int {
+	h := 1
+	for h < maxHeight && s.rnd.Intn(branching) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGE returns the first node whose key is greater than or equal to key,
+// and fills prev with the last node before it on every level if prev is not nil.
+func (s *skiplist) findGE(key []byte, prev *[maxHeight]*node) *node {
+	x := &s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for next := x.next[level]; next != nil && bytes.Compare(next.key, key) < 0; next = x.next[level] {
+			x = next
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// findLT returns the last node whose key is less than key, or nil if there is none.
+func (s *skiplist) findLT(key []byte) *node {
+	x := &s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for next := x.next[level]; next != nil && bytes.Compare(next.key, key) < 0; next = x.next[level] {
+			x = next
+		}
+	}
+	if x == &s.head {
+		return nil
+	}
+	return x
+}
+
+// findLast returns the last node, or nil if the list is empty.
+func (s *skiplist) findLast() *node {
+	x := &s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for next := x.next[level]; next != nil; next = x.next[level] {
+			x = next
+		}
+	}
+	if x == &s.head {
+		return nil
+	}
+	return x
+}
+
+func (s *skiplist) get(key []byte) ([]byte, bool) {
+	n := s.findGE(key, nil)
+	if n != nil && bytes.Equal(n.key, key) {
+		return n.value, true
+	}
+	return nil, false
+}
+
+// put stores the given slices without copying them.
+func (s *skiplist) put(key, value []byte) {
+	var prev [maxHeight]*node
+	n := s.findGE(key, &prev)
+	if n != nil && bytes.Equal(n.key, key) {
+		n.value = value
+		return
+	}
+
+	h := s.randomHeight()
+	if h > s.height {
+		for level := s.height; level < h; level++ {
+			prev[level] = &s.head
+		}
+		s.height = h
+	}
+	n = &node{key: key, value: value}
+	for level := 0; level < h; level++ {
+		n.next[level] = prev[level].next[level]
+		prev[level].next[level] = n
+	}
+}
+
+func (s *skiplist) delete(key []byte) {
+	var prev [maxHeight]*node
+	n := s.findGE(key, &prev)
+	if n == nil || !bytes.Equal(n.key, key) {
+		return
+	}
+	for level := 0; level < s.height; level++ {
+		if prev[level].next[level] != n {
+			break
+		}
+		prev[level].next[level] = n.next[level]
+	}
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+}
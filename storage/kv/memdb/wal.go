@@ -0,0 +1,130 @@
+package memdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opPut byte = iota + 1
+	opDelete
+)
+
+// crc(4) + payload length(4)
+const recordHeaderSize = 8
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptedRecord = errors.New("corrupted record")
+)
+
+type op struct {
+	kind  byte
+	key   []byte
+	value []byte
+}
+
+// encodeRecord encodes ops as a record: crc32c(4) | payload length(4) | payload,
+// the payload is a list of op(1) | key length(uvarint) | key [| value length(uvarint) | value].
+func encodeRecord(ops []op) []byte {
+	size := recordHeaderSize
+	for _, o := range ops {
+		size += 1 + 2*binary.MaxVarintLen32 + len(o.key) + len(o.value)
+	}
+	buf := make([]byte, recordHeaderSize, size)
+	for _, o := range ops {
+		buf = append(buf, o.kind)
+		buf = binary.AppendUvarint(buf, uint64(len(o.key)))
+		buf = append(buf, o.key...)
+		if o.kind == opPut {
+			buf = binary.AppendUvarint(buf, uint64(len(o.value)))
+			buf = append(buf, o.value...)
+		}
+	}
+	payload := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint32(buf[0:4], crc32.Checksum(payload, crcTable))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	return buf
+}
+
+func decodePayload(payload []byte) ([]op, error) {
+	var ops []op
+	for len(payload) > 0 {
+		o := op{kind: payload[0]}
+		payload = payload[1:]
+		if o.kind != opPut && o.kind != opDelete {
+			return nil, errCorruptedRecord
+		}
+		var err error
+		if o.key, payload, err = readBytes(payload); err != nil {
+			return nil, err
+		}
+		if o.kind == opPut {
+			if o.value, payload, err = readBytes(payload); err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	n, read := binary.Uvarint(buf)
+	if read <= 0 || uint64(len(buf)-read) < n {
+		return nil, nil, errCorruptedRecord
+	}
+	buf = buf[read:]
+	return buf[:n:n], buf[n:], nil
+}
+
+// replay applies every record read from r of the given size, it returns the size
+// of the valid records and stops at the torn last record. A corrupted record followed
+// by other records is not a torn write, so it fails the replay.
+func replay(r io.Reader, size int64, apply func(ops []op)) (int64, error) {
+	var (
+		br     = bufio.NewReader(r)
+		header [recordHeaderSize]byte
+		offset int64
+	)
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[4:8]))
+		if offset+recordHeaderSize+length > size {
+			return offset, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		last := offset+recordHeaderSize+length == size
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[0:4]) {
+			if last {
+				return offset, nil
+			}
+			return offset, errors.Wrapf(errCorruptedRecord, "checksum mismatch at offset %d", offset)
+		}
+		ops, err := decodePayload(payload)
+		if err != nil {
+			if last {
+				return offset, nil
+			}
+			return offset, errors.Wrapf(err, "at offset %d", offset)
+		}
+		apply(ops)
+		offset += recordHeaderSize + length
+	}
+}
@@ -0,0 +1,9 @@
+package kv
+
+import (
+	"testing"
+)
+
+func TestMemory_Conformance(t *testing.T) {
+	ConformanceSuite(t, NewMemory)
+}
@@ -0,0 +1,166 @@
+package kv
+
+import (
+	"bytes"
+)
+
+// TombstoneIterator is an Iterator which may yield deletion markers.
+type TombstoneIterator interface {
+	Iterator
+
+	// Tombstone reports whether the current key/value pair marks the key as deleted.
+	Tombstone() bool
+}
+
+type mergeDirection int
+
+const (
+	mergeSOI mergeDirection = iota
+	mergeEOI
+	mergeForward
+	mergeBackward
+)
+
+type mergedIterator struct {
+	iters []Iterator
+	valid []bool
+
+	dir     mergeDirection
+	key     []byte
+	current int
+}
+
+// MergeIterator returns an Iterator over the union of the key/value pairs of iters, in key order.
+// iters are ordered from the newest to the oldest, when several of them hold the same key,
+// the pair of the newest one wins and shadows the others. Keys whose winning pair is a
+// tombstone of a TombstoneIterator are skipped.
+// The returned Iterator takes the ownership of iters and is not positioned.
+func MergeIterator(iters ...Iterator) Iterator {
+	return &mergedIterator{
+		iters: iters,
+		valid: make([]bool, len(iters)),
+		dir:   mergeSOI,
+	}
+}
+
+func (m *mergedIterator) Next() bool {
+	switch m.dir {
+	case mergeEOI:
+		return false
+	case mergeSOI:
+		for i, it := range m.iters {
+			m.valid[i] = it.Next()
+		}
+	case mergeBackward:
+		// move every iterator to the first key after the current one
+		for i, it := range m.iters {
+			m.valid[i] = it.Seek(m.key)
+			if m.valid[i] && bytes.Equal(it.Key(), m.key) {
+				m.valid[i] = it.Next()
+			}
+		}
+	case mergeForward:
+		m.advance(m.key)
+	}
+	m.dir = mergeForward
+	return m.pick()
+}
+
+func (m *mergedIterator) Prev() bool {
+	switch m.dir {
+	case mergeSOI:
+		return false
+	case mergeEOI:
+		for i, it := range m.iters {
+			m.valid[i] = it.Prev()
+		}
+	case mergeForward:
+		// move every iterator to the last key before the current one,
+		// an iterator exhausted by Seek moves to its last pair by Prev
+		for i, it := range m.iters {
+			it.Seek(m.key)
+			m.valid[i] = it.Prev()
+		}
+	case mergeBackward:
+		m.advance(m.key)
+	}
+	m.dir = mergeBackward
+	return m.pick()
+}
+
+func (m *mergedIterator) Seek(key []byte) bool {
+	for i, it := range m.iters {
+		m.valid[i] = it.Seek(key)
+	}
+	m.dir = mergeForward
+	return m.pick()
+}
+
+// advance moves the iterators positioned at key one step in the current direction.
+func (m *mergedIterator) advance(key []byte) {
+	for i, it := range m.iters {
+		if !m.valid[i] || !bytes.Equal(it.Key(), key) {
+			continue
+		}
+		if m.dir == mergeForward {
+			m.valid[i] = it.Next()
+		} else {
+			m.valid[i] = it.Prev()
+		}
+	}
+}
+
+// pick positions the merged iterator at the smallest key (or the largest one backward)
+// among the iterators, skipping tombstones.
+func (m *mergedIterator) pick() bool {
+	for {
+		m.current = -1
+		var key []byte
+		for i, it := range m.iters {
+			if !m.valid[i] {
+				continue
+			}
+			k := it.Key()
+			if m.current == -1 {
+				m.current, key = i, k
+				continue
+			}
+			// the first iterator holding a key is the newest one
+			cmp := bytes.Compare(k, key)
+			if (m.dir == mergeForward && cmp < 0) || (m.dir == mergeBackward && cmp > 0) {
+				m.current, key = i, k
+			}
+		}
+
+		if m.current == -1 {
+			if m.dir == mergeForward {
+				m.dir = mergeEOI
+			} else {
+				m.dir = mergeSOI
+			}
+			m.key = nil
+			return false
+		}
+
+		m.key = append(m.key[:0], key...)
+		if t, ok := m.iters[m.current].(TombstoneIterator); ok && t.Tombstone() {
+			m.advance(m.key)
+			continue
+		}
+		return true
+	}
+}
+
+func (m *mergedIterator) Key() []byte {
+	if m.dir == mergeSOI || m.dir == mergeEOI {
+		return nil
+	}
+	return m.iters[m.current].Key()
+}
+
+func (m *mergedIterator) Value() []byte {
+	if m.dir == mergeSOI || m.dir == mergeEOI {
+		return nil
+	}
+	return m.iters[m.current].Value()
+}
@@ -0,0 +1,174 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeIterator(t *testing.T) {
+	newer, older := NewMemory(), NewMemory()
+	for _, key := range []string{"a", "c", "e"} {
+		older.Put([]byte(key), []byte("old"))
+	}
+	for _, key := range []string{"b", "c", "f"} {
+		newer.Put([]byte(key), []byte("new"))
+	}
+
+	it := MergeIterator(newer.Iterator(nil, nil), older.Iterator(nil, nil))
+	expected := []string{"a:old", "b:new", "c:new", "e:old", "f:new"}
+	var got []string
+	for it.Next() {
+		got = append(got, fmt.Sprintf("%s:%s", it.Key(), it.Value()))
+	}
+	assert.EqualValues(t, expected, got)
+	assert.Nil(t, it.Key())
+
+	got = nil
+	for it.Prev() {
+		got = append([]string{fmt.Sprintf("%s:%s", it.Key(), it.Value())}, got...)
+	}
+	assert.EqualValues(t, expected, got)
+
+	assert.True(t, it.Seek([]byte("d")))
+	assert.EqualValues(t, "e", string(it.Key()))
+	assert.True(t, it.Prev())
+	assert.EqualValues(t, "c", string(it.Key()))
+	assert.EqualValues(t, "new", string(it.Value()))
+
+	assert.False(t, MergeIterator().Next())
+}
+
+func TestOverlay(t *testing.T) {
+	lower := NewMemory()
+	lower.Put([]byte("a"), []byte("a"))
+	lower.Put([]byte("b"), []byte("b"))
+	o := NewOverlay(NewMemory(), lower)
+
+	o.Put([]byte("c"), []byte("c"))
+	o.Delete([]byte("a"))
+	o.Put([]byte("b"), []byte{})
+	assert.Nil(t, o.Get([]byte("a")))
+	assert.False(t, o.Has([]byte("a")))
+	assert.NotNil(t, o.Get([]byte("b")))
+	assert.Empty(t, o.Get([]byte("b")))
+	assert.EqualValues(t, []byte("c"), o.Get([]byte("c")))
+	// lower is untouched
+	assert.EqualValues(t, []byte("a"), lower.Get([]byte("a")))
+	assert.False(t, lower.Has([]byte("c")))
+
+	it := o.Prefix(nil)
+	require.True(t, it.Next())
+	assert.EqualValues(t, "b", string(it.Key()))
+	require.True(t, it.Next())
+	assert.EqualValues(t, "c", string(it.Key()))
+	require.False(t, it.Next())
+
+	o.Commit()
+	assert.False(t, lower.Has([]byte("a")))
+	assert.Empty(t, lower.Get([]byte("b")))
+	assert.EqualValues(t, []byte("c"), lower.Get([]byte("c")))
+	assert.False(t, o.(*overlay).upper.Prefix(nil).Next())
+
+	o.Delete([]byte("c"))
+	o.Discard()
+	assert.EqualValues(t, []byte("c"), o.Get([]byte("c")))
+}
+
+// TestOverlay_Random checks the merged view of an overlay against a single storage
+// applying the same operations.
+func TestOverlay_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		return []byte(fmt.Sprintf("key%02d", r.Intn(50)))
+	}
+
+	for round := 0; round < 20; round++ {
+		lower, expected := NewMemory(), NewMemory()
+		for i := 0; i < 30; i++ {
+			k := key()
+			lower.Put(k, k)
+			expected.Put(k, k)
+		}
+		o := NewOverlay(NewMemory(), lower)
+		for i := 0; i < 30; i++ {
+			k := key()
+			if r.Intn(2) == 0 {
+				o.Delete(k)
+				expected.Delete(k)
+			} else {
+				v := append(k, 'x')
+				o.Put(k, v)
+				expected.Put(k, v)
+			}
+		}
+
+		it, ref := o.Iterator(nil, nil), expected.Iterator(nil, nil)
+		for step := 0; step < 200; step++ {
+			var ok, refOk bool
+			switch r.Intn(3) {
+			case 0:
+				ok, refOk = it.Next(), ref.Next()
+			case 1:
+				ok, refOk = it.Prev(), ref.Prev()
+			case 2:
+				k := key()
+				ok, refOk = it.Seek(k), ref.Seek(k)
+			}
+			require.Equal(t, refOk, ok, "round %d step %d", round, step)
+			require.EqualValues(t, ref.Key(), it.Key(), "round %d step %d", round, step)
+			require.EqualValues(t, ref.Value(), it.Value(), "round %d step %d", round, step)
+		}
+	}
+}
+
+func TestOverlay_ConcurrentCommit(t *testing.T) {
+	o := NewOverlay(NewMemory(), NewMemory())
+	const writes = 1000
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// the overwrites of a key scanned by a running Commit are not lost
+		for i := 0; i < writes; i++ {
+			value := []byte(fmt.Sprintf("value%d", i))
+			if i%2 == 0 {
+				o.Put([]byte("key"), value)
+			} else {
+				batch := o.NewBatch()
+				batch.Put([]byte("key"), value)
+				batch.Commit()
+			}
+			if !assert.EqualValues(t, value, o.Get([]byte("key"))) {
+				return
+			}
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for committing := true; committing; {
+		select {
+		case <-done:
+			committing = false
+		default:
+		}
+		o.Commit()
+	}
+
+	assert.EqualValues(t, fmt.Sprintf("value%d", writes-1), o.(*overlay).lower.Get([]byte("key")))
+	assert.False(t, o.(*overlay).upper.Prefix(nil).Next())
+}
+
+func TestOverlay_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		return NewOverlay(NewMemory(), NewMemory())
+	})
+}
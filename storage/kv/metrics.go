@@ -0,0 +1,187 @@
+package kv
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	opGet         = "get"
+	opHas         = "has"
+	opPut         = "put"
+	opDelete      = "delete"
+	opBatchCommit = "batch_commit"
+)
+
+type MetricsOption func(m *meteredStorage)
+
+// WithSlowThreshold logs every operation slower than threshold to logger.
+func WithSlowThreshold(threshold time.Duration, logger logrus.FieldLogger) MetricsOption {
+	return func(m *meteredStorage) {
+		m.slowThreshold = threshold
+		m.logger = logger
+	}
+}
+
+type meteredStorage struct {
+	Storage
+
+	name          string
+	registry      prometheus.Registerer
+	slowThreshold time.Duration
+	logger        logrus.FieldLogger
+
+	opDuration    *prometheus.HistogramVec
+	batchSize     prometheus.Histogram
+	iterators     prometheus.Counter
+	iteratorSteps prometheus.Counter
+}
+
+// WithMetrics wraps s with latency and size instrumentation, the collectors are registered
+// to registry with a const label db=name, and unregistered when the storage is closed.
+func WithMetrics(s Storage, registry prometheus.Registerer, name string, opts ...MetricsOption) (Storage, error) {
+	labels := prometheus.Labels{"db": name}
+	m := &meteredStorage{
+		Storage:  s,
+		name:     name,
+		registry: registry,
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "kv_op_duration_seconds",
+			Help:        "latency of kv operations",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.000_001, 4, 12),
+		}, []string{"op"}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "kv_batch_size_bytes",
+			Help:        "size of committed kv batches",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(64, 4, 12),
+		}),
+		iterators: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "kv_iterators_total",
+			Help:        "number of created kv iterators",
+			ConstLabels: labels,
+		}),
+		iteratorSteps: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "kv_iterator_steps_total",
+			Help:        "number of kv iterator moves, including Next, Prev and Seek",
+			ConstLabels: labels,
+		}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if err := registerCollectors(registry, m.collectors()...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// registerCollectors registers every collector to registry, or none of them on failure.
+func registerCollectors(registry prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for i, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			unregisterCollectors(registry, collectors[:i]...)
+			return err
+		}
+	}
+	return nil
+}
+
+func unregisterCollectors(registry prometheus.Registerer, collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		registry.Unregister(c)
+	}
+}
+
+func (m *meteredStorage) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.opDuration, m.batchSize, m.iterators, m.iteratorSteps}
+}
+
+// observe records the latency of op and logs it if it is slow.
+func (m *meteredStorage) observe(op string, key []byte, start time.Time) {
+	elapsed := time.Since(start)
+	m.opDuration.WithLabelValues(op).Observe(elapsed.Seconds())
+	if m.logger != nil && m.slowThreshold > 0 && elapsed >= m.slowThreshold {
+		m.logger.WithFields(logrus.Fields{
+			"db":      m.name,
+			"op":      op,
+			"key":     fmt.Sprintf("%x", key),
+			"elapsed": elapsed,
+		}).Warn("Slow kv operation")
+	}
+}
+
+func (m *meteredStorage) Get(key []byte) []byte {
+	defer m.observe(opGet, key, time.Now())
+	return m.Storage.Get(key)
+}
+
+func (m *meteredStorage) Has(key []byte) bool {
+	defer m.observe(opHas, key, time.Now())
+	return m.Storage.Has(key)
+}
+
+func (m *meteredStorage) Put(key, value []byte) {
+	defer m.observe(opPut, key, time.Now())
+	m.Storage.Put(key, value)
+}
+
+func (m *meteredStorage) Delete(key []byte) {
+	defer m.observe(opDelete, key, time.Now())
+	m.Storage.Delete(key)
+}
+
+func (m *meteredStorage) Iterator(start, end []byte) Iterator {
+	m.iterators.Inc()
+	return &meteredIterator{Iterator: m.Storage.Iterator(start, end), steps: m.iteratorSteps}
+}
+
+func (m *meteredStorage) Prefix(prefix []byte) Iterator {
+	m.iterators.Inc()
+	return &meteredIterator{Iterator: m.Storage.Prefix(prefix), steps: m.iteratorSteps}
+}
+
+func (m *meteredStorage) NewBatch() Batch {
+	return &meteredBatch{Batch: m.Storage.NewBatch(), storage: m}
+}
+
+func (m *meteredStorage) Close() error {
+	unregisterCollectors(m.registry, m.collectors()...)
+	return m.Storage.Close()
+}
+
+type meteredIterator struct {
+	Iterator
+	steps prometheus.Counter
+}
+
+func (it *meteredIterator) Next() bool {
+	it.steps.Inc()
+	return it.Iterator.Next()
+}
+
+func (it *meteredIterator) Prev() bool {
+	it.steps.Inc()
+	return it.Iterator.Prev()
+}
+
+func (it *meteredIterator) Seek(key []byte) bool {
+	it.steps.Inc()
+	return it.Iterator.Seek(key)
+}
+
+type meteredBatch struct {
+	Batch
+	storage *meteredStorage
+}
+
+func (b *meteredBatch) Commit() {
+	b.storage.batchSize.Observe(float64(b.Batch.Size()))
+	defer b.storage.observe(opBatchCommit, nil, time.Now())
+	b.Batch.Commit()
+}
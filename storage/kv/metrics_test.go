@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	logger, hook := test.NewNullLogger()
+
+	s, err := WithMetrics(NewMemory(), registry, "state", WithSlowThreshold(time.Nanosecond, logger))
+	require.Nil(t, err)
+	_, err = WithMetrics(NewMemory(), registry, "state")
+	require.NotNil(t, err)
+
+	s.Put([]byte("key"), []byte("value"))
+	assert.EqualValues(t, []byte("value"), s.Get([]byte("key")))
+	assert.True(t, s.Has([]byte("key")))
+	s.Delete([]byte("key"))
+
+	batch := s.NewBatch()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Commit()
+
+	iter := s.Prefix([]byte("key"))
+	cnt := 0
+	for iter.Next() {
+		cnt++
+	}
+	assert.EqualValues(t, 10, cnt)
+
+	m := s.(*meteredStorage)
+	assert.EqualValues(t, 5, testutil.CollectAndCount(m.opDuration))
+	assert.EqualValues(t, 1, testutil.ToFloat64(m.iterators))
+	assert.EqualValues(t, 11, testutil.ToFloat64(m.iteratorSteps))
+	assert.EqualValues(t, 5, len(hook.AllEntries()))
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, opBatchCommit, hook.LastEntry().Data["op"])
+
+	require.Nil(t, s.Close())
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	assert.Empty(t, families)
+	_, err = WithMetrics(NewMemory(), registry, "state")
+	require.Nil(t, err)
+}
+
+func TestWithMetrics_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		s, err := WithMetrics(NewMemory(), prometheus.NewRegistry(), "state")
+		require.Nil(t, err)
+		return s
+	})
+}
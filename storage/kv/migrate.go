@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMigrateChunkSize = 10000
+
+var (
+	ErrMigrateChecksum = errors.New("migrated chunk checksum mismatch")
+
+	crc64Table = crc64.MakeTable(crc64.ECMA)
+)
+
+type MigrateOptions struct {
+	// ChunkSize is the max number of keys copied per batch, defaults to 10000.
+	ChunkSize int
+
+	// StartKey resumes an interrupted migration from the given key (inclusive),
+	// usually the NextKey of the last reported MigrateProgress.
+	StartKey []byte
+
+	// BytesPerSecond limits the copy throughput, 0 means unlimited.
+	BytesPerSecond int
+
+	// Verify reads every committed chunk back from dst and compares its checksum with src.
+	Verify bool
+
+	// Progress is called after every committed non-empty chunk.
+	Progress func(progress MigrateProgress)
+}
+
+type MigrateProgress struct {
+	// Keys is the number of keys copied by this run.
+	Keys uint64
+
+	// Bytes is the total size of the keys and values copied by this run.
+	Bytes uint64
+
+	// Checksum is the crc64 of every key/value pair copied by this run in key order.
+	Checksum uint64
+
+	// NextKey is the key to resume the migration from, nil once the migration completes.
+	NextKey []byte
+}
+
+// Migrate copies every key/value pair of src to dst in key order, chunk by chunk.
+// Each chunk is committed as a single dst batch, so an interrupted migration can be
+// resumed from the last reported NextKey. The source is read through an iterator,
+// so writes to src after Migrate starts may not be copied, stop the writers or
+// compare both storages afterwards for a consistent copy.
+func Migrate(ctx context.Context, src, dst Storage, opts *MigrateOptions) (MigrateProgress, error) {
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultMigrateChunkSize
+	}
+
+	var (
+		key      []byte // own copy of the last copied key
+		progress = MigrateProgress{NextKey: opts.StartKey}
+		total    = crc64.New(crc64Table)
+		started  = time.Now()
+		it       = src.Iterator(opts.StartKey, nil)
+		batch    = dst.NewBatch()
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		var (
+			chunk = crc64.New(crc64Table)
+			keys  int
+			size  uint64
+			first []byte
+			more  bool
+		)
+		batch.Reset()
+		for keys < chunkSize {
+			if more = it.Next(); !more {
+				break
+			}
+			key = append(key[:0], it.Key()...)
+			value := it.Value()
+			if first == nil {
+				first = append([]byte{}, key...)
+			}
+			// key and value are reused by the loop and the iterator, the batch gets its own copies
+			batch.Put(append([]byte(nil), key...), append([]byte(nil), value...))
+			writeChecksum(chunk, key, value)
+			writeChecksum(total, key, value)
+			keys++
+			size += uint64(len(key) + len(value))
+		}
+
+		if keys > 0 {
+			batch.Commit()
+			if opts.Verify {
+				// the chunk covers [first, last key], whose exclusive end is last key + 0x00
+				end := append(append([]byte{}, key...), 0)
+				if err := verifyChunk(dst, first, end, chunk.Sum64()); err != nil {
+					return progress, errors.Wrapf(err, "chunk [%x, %x]", first, key)
+				}
+			}
+			progress.Keys += uint64(keys)
+			progress.Bytes += size
+			progress.Checksum = total.Sum64()
+		}
+		if more {
+			progress.NextKey = append(append([]byte{}, key...), 0)
+		} else {
+			progress.NextKey = nil
+		}
+		if keys > 0 && opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if !more {
+			return progress, nil
+		}
+
+		if opts.BytesPerSecond > 0 {
+			expected := time.Duration(float64(progress.Bytes) / float64(opts.BytesPerSecond) * float64(time.Second))
+			if wait := expected - time.Since(started); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return progress, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
+
+func writeChecksum(h hash.Hash64, key, value []byte) {
+	var length [binary.MaxVarintLen64]byte
+	h.Write(length[:binary.PutUvarint(length[:], uint64(len(key)))])
+	h.Write(key)
+	h.Write(length[:binary.PutUvarint(length[:], uint64(len(value)))])
+	h.Write(value)
+}
+
+func verifyChunk(dst Storage, start, end []byte, expected uint64) error {
+	h := crc64.New(crc64Table)
+	it := dst.Iterator(start, end)
+	for it.Next() {
+		writeChecksum(h, it.Key(), it.Value())
+	}
+	if h.Sum64() != expected {
+		return ErrMigrateChecksum
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMigrateSource(n int) Storage {
+	src := NewMemory()
+	batch := src.NewBatch()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Commit()
+	return src
+}
+
+func TestMigrate(t *testing.T) {
+	src, dst := newMigrateSource(1000), NewMemory()
+
+	var reports []MigrateProgress
+	progress, err := Migrate(context.Background(), src, dst, &MigrateOptions{
+		ChunkSize: 300,
+		Verify:    true,
+		Progress: func(progress MigrateProgress) {
+			reports = append(reports, progress)
+		},
+	})
+	require.Nil(t, err)
+	assert.EqualValues(t, 1000, progress.Keys)
+	assert.EqualValues(t, 14000, progress.Bytes)
+	assert.Nil(t, progress.NextKey)
+	require.Len(t, reports, 4)
+	assert.EqualValues(t, "key0299\x00", string(reports[0].NextKey))
+	assert.EqualValues(t, 900, reports[2].Keys)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		assert.EqualValues(t, []byte(key), dst.Get([]byte(key)))
+	}
+
+	// the checksum only depends on the copied data
+	again, err := Migrate(context.Background(), src, NewMemory(), nil)
+	require.Nil(t, err)
+	assert.EqualValues(t, progress.Checksum, again.Checksum)
+}
+
+func TestMigrate_FullLastChunk(t *testing.T) {
+	src, dst := newMigrateSource(600), NewMemory()
+
+	var reports []MigrateProgress
+	progress, err := Migrate(context.Background(), src, dst, &MigrateOptions{
+		ChunkSize: 300,
+		Verify:    true,
+		Progress: func(progress MigrateProgress) {
+			reports = append(reports, progress)
+		},
+	})
+	require.Nil(t, err)
+	assert.EqualValues(t, 600, progress.Keys)
+	assert.Nil(t, progress.NextKey)
+	require.Len(t, reports, 2)
+	assert.EqualValues(t, 300, reports[0].Keys)
+	assert.EqualValues(t, 600, reports[1].Keys)
+
+	// nothing to copy, nothing to report
+	reports = nil
+	progress, err = Migrate(context.Background(), NewMemory(), dst, &MigrateOptions{
+		Progress: func(progress MigrateProgress) {
+			reports = append(reports, progress)
+		},
+	})
+	require.Nil(t, err)
+	assert.Zero(t, progress.Keys)
+	assert.Empty(t, reports)
+}
+
+func TestMigrate_Resume(t *testing.T) {
+	src, dst := newMigrateSource(1000), NewMemory()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress, err := Migrate(ctx, src, dst, &MigrateOptions{
+		ChunkSize: 100,
+		Progress: func(progress MigrateProgress) {
+			if progress.Keys == 400 {
+				cancel()
+			}
+		},
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.EqualValues(t, 400, progress.Keys)
+	assert.True(t, dst.Has([]byte("key0399")))
+	assert.False(t, dst.Has([]byte("key0400")))
+
+	progress, err = Migrate(context.Background(), src, dst, &MigrateOptions{
+		ChunkSize: 100,
+		StartKey:  progress.NextKey,
+		Verify:    true,
+	})
+	require.Nil(t, err)
+	assert.EqualValues(t, 600, progress.Keys)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		assert.EqualValues(t, []byte(key), dst.Get([]byte(key)))
+	}
+}
+
+func TestMigrate_VerifyMismatch(t *testing.T) {
+	src, dst := newMigrateSource(100), NewMemory()
+	// a stale key in dst within the migrated range
+	dst.Put([]byte("key0050x"), []byte("stale"))
+
+	progress, err := Migrate(context.Background(), src, dst, &MigrateOptions{ChunkSize: 40, Verify: true})
+	require.ErrorIs(t, err, ErrMigrateChecksum)
+	assert.EqualValues(t, 40, progress.Keys)
+}
+
+func TestMigrate_RateLimit(t *testing.T) {
+	src, dst := newMigrateSource(100), NewMemory()
+
+	start := time.Now()
+	_, err := Migrate(context.Background(), src, dst, &MigrateOptions{ChunkSize: 10, BytesPerSecond: 16000})
+	require.Nil(t, err)
+	// 1400 bytes at 16000 bytes/s
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
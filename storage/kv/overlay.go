@@ -0,0 +1,172 @@
+package kv
+
+import "sync"
+
+const (
+	overlayTombstone byte = iota
+	overlayValue
+)
+
+// OverlayStorage is a Storage layering a temporary upper storage over a persistent lower one.
+type OverlayStorage interface {
+	Storage
+
+	// Commit writes the changes of the upper layer to the lower one in a single batch,
+	// and empties the upper layer. Writes wait for Commit to finish.
+	Commit()
+
+	// Discard drops the changes of the upper layer. Writes wait for Discard to finish.
+	Discard()
+}
+
+type overlay struct {
+	upper Storage
+	lower Storage
+
+	// lock keeps the writes out of Commit and Discard, so that a write between the scan
+	// of upper and the removal of the scanned keys is not lost, writes hold the read lock
+	lock sync.RWMutex
+}
+
+// NewOverlay returns a Storage layering upper, usually NewMemory(), over lower.
+// Writes only go to upper, where deletes are kept as tombstones shadowing lower.
+// Reads and iterators see upper as if it were already merged into lower.
+// upper must be empty and dedicated to the overlay, Close only closes upper.
+func NewOverlay(upper, lower Storage) OverlayStorage {
+	return &overlay{
+		upper: upper,
+		lower: lower,
+	}
+}
+
+func overlayEncode(value []byte) []byte {
+	encoded := make([]byte, 1+len(value))
+	encoded[0] = overlayValue
+	copy(encoded[1:], value)
+	return encoded
+}
+
+func (o *overlay) Put(key, value []byte) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	o.upper.Put(key, overlayEncode(value))
+}
+
+func (o *overlay) Delete(key []byte) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	o.upper.Put(key, []byte{overlayTombstone})
+}
+
+func (o *overlay) Get(key []byte) []byte {
+	if encoded := o.upper.Get(key); encoded != nil {
+		if encoded[0] == overlayTombstone {
+			return nil
+		}
+		return encoded[1:]
+	}
+	return o.lower.Get(key)
+}
+
+func (o *overlay) Has(key []byte) bool {
+	if encoded := o.upper.Get(key); encoded != nil {
+		return encoded[0] != overlayTombstone
+	}
+	return o.lower.Has(key)
+}
+
+func (o *overlay) Iterator(start, end []byte) Iterator {
+	return MergeIterator(&overlayIterator{Iterator: o.upper.Iterator(start, end)}, o.lower.Iterator(start, end))
+}
+
+func (o *overlay) Prefix(prefix []byte) Iterator {
+	return MergeIterator(&overlayIterator{Iterator: o.upper.Prefix(prefix)}, o.lower.Prefix(prefix))
+}
+
+func (o *overlay) NewBatch() Batch {
+	return &overlayBatch{Batch: o.upper.NewBatch(), o: o}
+}
+
+func (o *overlay) Close() error {
+	return o.upper.Close()
+}
+
+func (o *overlay) Commit() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var (
+		lower = o.lower.NewBatch()
+		upper = o.upper.NewBatch()
+		it    = o.upper.Prefix(nil)
+	)
+	for it.Next() {
+		key, encoded := it.Key(), it.Value()
+		if encoded[0] == overlayTombstone {
+			lower.Delete(key)
+		} else {
+			lower.Put(key, encoded[1:])
+		}
+		upper.Delete(key)
+	}
+	lower.Commit()
+	upper.Commit()
+}
+
+func (o *overlay) Discard() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	batch := o.upper.NewBatch()
+	it := o.upper.Prefix(nil)
+	for it.Next() {
+		batch.Delete(it.Key())
+	}
+	batch.Commit()
+}
+
+type overlayIterator struct {
+	Iterator
+}
+
+func (it *overlayIterator) Tombstone() bool {
+	encoded := it.Iterator.Value()
+	return encoded != nil && encoded[0] == overlayTombstone
+}
+
+func (it *overlayIterator) Value() []byte {
+	encoded := it.Iterator.Value()
+	if encoded == nil {
+		return nil
+	}
+	return encoded[1:]
+}
+
+type overlayBatch struct {
+	Batch
+	o    *overlay
+	size int
+}
+
+func (b *overlayBatch) Put(key, value []byte) {
+	b.Batch.Put(key, overlayEncode(value))
+	b.size += len(key) + len(value)
+}
+
+func (b *overlayBatch) Delete(key []byte) {
+	b.Batch.Put(key, []byte{overlayTombstone})
+	b.size += len(key)
+}
+
+func (b *overlayBatch) Commit() {
+	b.o.lock.RLock()
+	defer b.o.lock.RUnlock()
+	b.Batch.Commit()
+}
+
+func (b *overlayBatch) Size() int {
+	return b.size
+}
+
+func (b *overlayBatch) Reset() {
+	b.Batch.Reset()
+	b.size = 0
+}
@@ -0,0 +1,213 @@
+package pebble
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// Collector exports the internal metrics of a pebble DB, it reads pebble.Metrics on every scrape.
+type Collector struct {
+	db       *pebble.DB
+	registry prometheus.Registerer
+
+	metrics      []dbMetric
+	levelMetrics []levelMetric
+
+	writeStalls        atomic.Int64
+	writeStallDuration atomic.Int64 // nanoseconds
+	writeStallStart    atomic.Int64 // unix nanoseconds of the ongoing stall, 0 if none
+	writeStallsDesc    *prometheus.Desc
+	writeStallTimeDesc *prometheus.Desc
+}
+
+type dbMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(m *pebble.Metrics) float64
+}
+
+type levelMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(m *pebble.LevelMetrics) float64
+}
+
+func newCollector(registry prometheus.Registerer, namespace, subSystem string, labels prometheus.Labels) *Collector {
+	c := &Collector{registry: registry}
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subSystem, "kv_pebble_"+name), help, variableLabels, labels)
+	}
+	gauge := func(name, help string, value func(m *pebble.Metrics) float64) {
+		c.metrics = append(c.metrics, dbMetric{desc: desc(name, help), valueType: prometheus.GaugeValue, value: value})
+	}
+	counter := func(name, help string, value func(m *pebble.Metrics) float64) {
+		c.metrics = append(c.metrics, dbMetric{desc: desc(name, help), valueType: prometheus.CounterValue, value: value})
+	}
+	levelGauge := func(name, help string, value func(m *pebble.LevelMetrics) float64) {
+		c.levelMetrics = append(c.levelMetrics, levelMetric{desc: desc(name, help, "level"), valueType: prometheus.GaugeValue, value: value})
+	}
+	levelCounter := func(name, help string, value func(m *pebble.LevelMetrics) float64) {
+		c.levelMetrics = append(c.levelMetrics, levelMetric{desc: desc(name, help, "level"), valueType: prometheus.CounterValue, value: value})
+	}
+
+	gauge("disk_space_usage_bytes", "total disk space used by the DB, in bytes",
+		func(m *pebble.Metrics) float64 { return float64(m.DiskSpaceUsage()) })
+	gauge("read_amplification", "current read amplification of the DB",
+		func(m *pebble.Metrics) float64 { return float64(m.ReadAmp()) })
+
+	levelGauge("level_files", "number of sstables in the level",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.NumFiles) })
+	levelGauge("level_size_bytes", "total size of the sstables in the level, in bytes",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.Size) })
+	levelGauge("level_score", "compaction score of the level",
+		func(l *pebble.LevelMetrics) float64 { return l.Score })
+	levelGauge("level_sublevels", "number of sublevels within the level, only meaningful for L0",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.Sublevels) })
+	levelCounter("level_bytes_in_total", "bytes written into the level",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesIn) })
+	levelCounter("level_bytes_read_total", "bytes read by compactions from the level",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesRead) })
+	levelCounter("level_bytes_compacted_total", "bytes written to the level by compactions",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesCompacted) })
+	levelCounter("level_bytes_flushed_total", "bytes written to the level by flushes",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesFlushed) })
+	levelCounter("level_bytes_ingested_total", "bytes ingested into the level",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesIngested) })
+	levelCounter("level_bytes_moved_total", "bytes moved into the level by move compactions",
+		func(l *pebble.LevelMetrics) float64 { return float64(l.BytesMoved) })
+
+	counter("compactions_total", "number of compactions",
+		func(m *pebble.Metrics) float64 { return float64(m.Compact.Count) })
+	counter("compaction_duration_seconds_total", "cumulative time spent in compactions",
+		func(m *pebble.Metrics) float64 { return m.Compact.Duration.Seconds() })
+	gauge("compaction_debt_bytes", "estimated bytes that need compacting for the LSM to reach a stable state",
+		func(m *pebble.Metrics) float64 { return float64(m.Compact.EstimatedDebt) })
+	gauge("compactions_in_progress", "number of compactions in progress",
+		func(m *pebble.Metrics) float64 { return float64(m.Compact.NumInProgress) })
+	gauge("compaction_in_progress_bytes", "bytes present in sstables being written by in progress compactions",
+		func(m *pebble.Metrics) float64 { return float64(m.Compact.InProgressBytes) })
+	gauge("compaction_marked_files", "number of files marked for compaction",
+		func(m *pebble.Metrics) float64 { return float64(m.Compact.MarkedFiles) })
+
+	counter("flushes_total", "number of memtable flushes",
+		func(m *pebble.Metrics) float64 { return float64(m.Flush.Count) })
+	gauge("flushes_in_progress", "number of flushes in progress",
+		func(m *pebble.Metrics) float64 { return float64(m.Flush.NumInProgress) })
+	counter("ingestions_total", "number of sstable ingestions",
+		func(m *pebble.Metrics) float64 { return float64(m.Ingest.Count) })
+
+	gauge("memtable_size_bytes", "bytes allocated by memtables and large batches",
+		func(m *pebble.Metrics) float64 { return float64(m.MemTable.Size) })
+	gauge("memtable_count", "number of memtables",
+		func(m *pebble.Metrics) float64 { return float64(m.MemTable.Count) })
+	gauge("memtable_zombie_size_bytes", "bytes allocated by zombie memtables",
+		func(m *pebble.Metrics) float64 { return float64(m.MemTable.ZombieSize) })
+	gauge("memtable_zombie_count", "number of zombie memtables",
+		func(m *pebble.Metrics) float64 { return float64(m.MemTable.ZombieCount) })
+
+	gauge("block_cache_size_bytes", "bytes in use by the block cache",
+		func(m *pebble.Metrics) float64 { return float64(m.BlockCache.Size) })
+	gauge("block_cache_count", "number of blocks in the block cache",
+		func(m *pebble.Metrics) float64 { return float64(m.BlockCache.Count) })
+	counter("block_cache_hits_total", "number of block cache hits",
+		func(m *pebble.Metrics) float64 { return float64(m.BlockCache.Hits) })
+	counter("block_cache_misses_total", "number of block cache misses",
+		func(m *pebble.Metrics) float64 { return float64(m.BlockCache.Misses) })
+	gauge("block_cache_hit_rate", "block cache hit rate since the DB was opened",
+		func(m *pebble.Metrics) float64 { return hitRate(m.BlockCache.Hits, m.BlockCache.Misses) })
+
+	gauge("table_cache_size_bytes", "bytes in use by the table cache",
+		func(m *pebble.Metrics) float64 { return float64(m.TableCache.Size) })
+	gauge("table_cache_count", "number of tables in the table cache",
+		func(m *pebble.Metrics) float64 { return float64(m.TableCache.Count) })
+	counter("table_cache_hits_total", "number of table cache hits",
+		func(m *pebble.Metrics) float64 { return float64(m.TableCache.Hits) })
+	counter("table_cache_misses_total", "number of table cache misses",
+		func(m *pebble.Metrics) float64 { return float64(m.TableCache.Misses) })
+	gauge("table_cache_hit_rate", "table cache hit rate since the DB was opened",
+		func(m *pebble.Metrics) float64 { return hitRate(m.TableCache.Hits, m.TableCache.Misses) })
+	gauge("table_iterators", "number of open sstable iterators",
+		func(m *pebble.Metrics) float64 { return float64(m.TableIters) })
+	gauge("table_obsolete_size_bytes", "bytes of obsolete sstables awaiting deletion",
+		func(m *pebble.Metrics) float64 { return float64(m.Table.ObsoleteSize) })
+	gauge("table_zombie_size_bytes", "bytes of sstables no longer referenced by the current version",
+		func(m *pebble.Metrics) float64 { return float64(m.Table.ZombieSize) })
+
+	counter("filter_hits_total", "number of data block reads avoided by the filter policy",
+		func(m *pebble.Metrics) float64 { return float64(m.Filter.Hits) })
+	counter("filter_misses_total", "number of filter checks unable to avoid a data block read",
+		func(m *pebble.Metrics) float64 { return float64(m.Filter.Misses) })
+
+	gauge("wal_files", "number of live WAL files",
+		func(m *pebble.Metrics) float64 { return float64(m.WAL.Files) })
+	gauge("wal_size_bytes", "size of the live data in the WAL files",
+		func(m *pebble.Metrics) float64 { return float64(m.WAL.Size) })
+	gauge("wal_physical_size_bytes", "physical size of the WAL files on disk",
+		func(m *pebble.Metrics) float64 { return float64(m.WAL.PhysicalSize) })
+	counter("wal_bytes_in_total", "logical bytes written to the WAL",
+		func(m *pebble.Metrics) float64 { return float64(m.WAL.BytesIn) })
+	counter("wal_bytes_written_total", "physical bytes written to the WAL",
+		func(m *pebble.Metrics) float64 { return float64(m.WAL.BytesWritten) })
+
+	gauge("snapshots", "number of open snapshots",
+		func(m *pebble.Metrics) float64 { return float64(m.Snapshots.Count) })
+	gauge("tombstones", "approximate number of point tombstones in the DB",
+		func(m *pebble.Metrics) float64 { return float64(m.Keys.TombstoneCount) })
+
+	c.writeStallsDesc = desc("write_stalls_total", "number of write stalls")
+	c.writeStallTimeDesc = desc("write_stall_duration_seconds_total", "cumulative time writes were stalled")
+	return c
+}
+
+func hitRate(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// eventListener returns the listener tracking the write stalls of the DB.
+func (c *Collector) eventListener() pebble.EventListener {
+	return pebble.EventListener{
+		WriteStallBegin: func(pebble.WriteStallBeginInfo) {
+			c.writeStalls.Add(1)
+			c.writeStallStart.Store(time.Now().UnixNano())
+		},
+		WriteStallEnd: func() {
+			if start := c.writeStallStart.Swap(0); start != 0 {
+				c.writeStallDuration.Add(time.Now().UnixNano() - start)
+			}
+		},
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+	for _, m := range c.levelMetrics {
+		ch <- m.desc
+	}
+	ch <- c.writeStallsDesc
+	ch <- c.writeStallTimeDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	metrics := c.db.Metrics()
+	for _, m := range c.metrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, m.value(metrics))
+	}
+	for level := range metrics.Levels {
+		for _, m := range c.levelMetrics {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, m.value(&metrics.Levels[level]), strconv.Itoa(level))
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.writeStallsDesc, prometheus.CounterValue, float64(c.writeStalls.Load()))
+	ch <- prometheus.MustNewConstMetric(c.writeStallTimeDesc, prometheus.CounterValue, time.Duration(c.writeStallDuration.Load()).Seconds())
+}
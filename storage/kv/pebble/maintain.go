@@ -0,0 +1,64 @@
+package pebble
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/axiomesh/axiom-kit/storage/kv"
+)
+
+var _ kv.Maintainer = (*pdb)(nil)
+
+// Compact flattens the underlying data store for the given key range [start, end).
+// A nil start is treated as a key before all keys in the DB,
+// and a nil end is treated as a key after all keys in the DB.
+func (p *pdb) Compact(start, end []byte) error {
+	end, ok, err := p.upperBound(start, end)
+	if err != nil || !ok {
+		return err
+	}
+	return p.db.Compact(start, end, true)
+}
+
+// Checkpoint writes an online hard-linked snapshot of the DB to dir,
+// the WAL is flushed before the snapshot is taken.
+func (p *pdb) Checkpoint(dir string) error {
+	return p.db.Checkpoint(dir, pebble.WithFlushedWAL())
+}
+
+// Flush flushes the memtable to stable storage and waits for it to finish.
+func (p *pdb) Flush() error {
+	return p.db.Flush()
+}
+
+// EstimateDiskUsage returns the estimated disk usage in bytes of the key range [start, end).
+// A nil end is treated as a key after all keys in the DB.
+func (p *pdb) EstimateDiskUsage(start, end []byte) (uint64, error) {
+	end, ok, err := p.upperBound(start, end)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return p.db.EstimateDiskUsage(start, end)
+}
+
+// upperBound resolves a nil end to an exclusive bound right after the
+// last key of the DB. It returns false if there is nothing in [start, end).
+func (p *pdb) upperBound(start, end []byte) ([]byte, bool, error) {
+	if end != nil {
+		return end, bytes.Compare(start, end) < 0, nil
+	}
+	it, err := p.db.NewIter(&pebble.IterOptions{LowerBound: start})
+	if err != nil {
+		return nil, false, err
+	}
+	defer it.Close()
+	if !it.Last() {
+		return nil, false, nil
+	}
+	// the smallest key greater than the last key
+	last := it.Key()
+	end = make([]byte, len(last)+1)
+	copy(end, last)
+	return end, true, nil
+}
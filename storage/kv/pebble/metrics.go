@@ -9,6 +9,8 @@ type Metrics struct {
 	diskWriteThroughput      prometheus.Gauge // Gauge for measuring disk data written throughput
 	walWriteThroughput       prometheus.Gauge // Gauge for measuring wal data written throughput
 	effectiveWriteThroughput prometheus.Gauge // Gauge for measuring the kv effective amount of data written throughput
+
+	collector *Collector // Collector exporting all the internal pebble metrics
 }
 
 type MetricsOption func(pebbleMetrics *Metrics)
@@ -60,3 +62,12 @@ func WithEffectiveWriteThroughput(namespace, subSystem, namePrefix string) Metri
 		prometheus.MustRegister(pebbleMetrics.effectiveWriteThroughput)
 	}
 }
+
+// WithCollector exports all the internal pebble metrics through a Collector registered to the given registry.
+// The const labels are attached to every metric, so that multiple DBs can share one registry.
+// The Collector is unregistered when the DB is closed.
+func WithCollector(registry prometheus.Registerer, namespace, subSystem string, labels prometheus.Labels) MetricsOption {
+	return func(pebbleMetrics *Metrics) {
+		pebbleMetrics.collector = newCollector(registry, namespace, subSystem, labels)
+	}
+}
@@ -28,6 +28,21 @@ type pdb struct {
 }
 
 func New(path string, opts *pebble.Options, wo *pebble.WriteOptions, logger logrus.FieldLogger, metricsOpts ...MetricsOption) (kv.Storage, error) {
+	metrics := &Metrics{}
+	for _, opt := range metricsOpts {
+		opt(metrics)
+	}
+
+	if metrics.collector != nil {
+		// hook the write stall events without modifying the caller's options
+		opts = opts.Clone()
+		listener := metrics.collector.eventListener()
+		if opts.EventListener != nil {
+			listener = pebble.TeeEventListener(*opts.EventListener, listener)
+		}
+		opts.EventListener = &listener
+	}
+
 	db, err := pebble.Open(path, opts)
 	if err != nil {
 		return nil, err
@@ -36,12 +51,16 @@ func New(path string, opts *pebble.Options, wo *pebble.WriteOptions, logger logr
 	pebbleDB := &pdb{
 		db:      db,
 		wo:      wo,
-		metrics: &Metrics{},
+		metrics: metrics,
 		logger:  logger,
 	}
 
-	for _, opt := range metricsOpts {
-		opt(pebbleDB.metrics)
+	if metrics.collector != nil {
+		metrics.collector.db = db
+		if err := metrics.collector.registry.Register(metrics.collector); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
 	}
 
 	go pebbleDB.meter(metricsGatherInterval)
@@ -116,13 +135,11 @@ func (p *pdb) Iterator(start, end []byte) kv.Iterator {
 		}).Panic("Pebble NewIter failed")
 		return nil
 	}
-	iter := &iter{
+	return &iter{
 		iter:       it,
 		positioned: false,
 		logger:     p.logger,
 	}
-	iter.iter.First()
-	return iter
 }
 
 func (p *pdb) Prefix(prefix []byte) kv.Iterator {
@@ -137,13 +154,11 @@ func (p *pdb) Prefix(prefix []byte) kv.Iterator {
 		}).Panic("Pebble NewIter failed")
 		return nil
 	}
-	iter := &iter{
+	return &iter{
 		iter:       it,
 		positioned: false,
 		logger:     p.logger,
 	}
-	iter.iter.First()
-	return iter
 }
 
 func (p *pdb) NewBatch() kv.Batch {
@@ -155,6 +170,9 @@ func (p *pdb) NewBatch() kv.Batch {
 }
 
 func (p *pdb) Close() error {
+	if p.metrics.collector != nil {
+		p.metrics.collector.registry.Unregister(p.metrics.collector)
+	}
 	err := p.db.Close()
 	if err != nil {
 		return err
@@ -170,6 +188,9 @@ type iter struct {
 }
 
 func (it *iter) Prev() bool {
+	if !it.positioned {
+		return false
+	}
 	return it.iter.Prev()
 }
 
@@ -181,17 +202,17 @@ func (it *iter) Seek(key []byte) bool {
 }
 
 func (it *iter) Next() bool {
-	if !it.iter.Valid() {
-		return false
-	}
 	if !it.positioned {
 		it.positioned = true
-		return true
+		return it.iter.First()
 	}
 	return it.iter.Next()
 }
 
 func (it *iter) Key() []byte {
+	if !it.positioned || !it.iter.Valid() {
+		return nil
+	}
 	key := it.iter.Key()
 	ret := make([]byte, len(key))
 	copy(ret, key)
@@ -199,6 +220,9 @@ func (it *iter) Key() []byte {
 }
 
 func (it *iter) Value() []byte {
+	if !it.positioned || !it.iter.Valid() {
+		return nil
+	}
 	val, err := it.iter.ValueAndErr()
 	if err != nil {
 		it.logger.WithFields(logrus.Fields{
@@ -206,6 +230,10 @@ func (it *iter) Value() []byte {
 		}).Panic("Pebble iter value failed")
 		return nil
 	}
+	if val == nil {
+		// keep empty values distinguishable from exhausted iterators
+		return []byte{}
+	}
 	return val
 }
 
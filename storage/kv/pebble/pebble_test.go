@@ -1,13 +1,17 @@
 package pebble
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/bloom"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -409,6 +413,125 @@ func TestPdb_BatchReset(t *testing.T) {
 	}
 }
 
+func TestPdb_Maintainer(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, nil, nil, testLogger)
+	require.Nil(t, err)
+	m, ok := s.(kv.Maintainer)
+	require.True(t, ok)
+
+	// nothing to do on an empty db
+	require.Nil(t, m.Compact(nil, nil))
+	usage, err := m.EstimateDiskUsage(nil, nil)
+	require.Nil(t, err)
+	assert.EqualValues(t, 0, usage)
+
+	batch := s.NewBatch()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		batch.Put([]byte(key), []byte(key))
+	}
+	batch.Commit()
+
+	require.Nil(t, m.Flush())
+	usage, err = m.EstimateDiskUsage(nil, nil)
+	require.Nil(t, err)
+	assert.True(t, usage > 0)
+
+	for i := 0; i < 500; i++ {
+		s.Delete([]byte(fmt.Sprintf("key%d", i)))
+	}
+	require.Nil(t, m.Compact(nil, nil))
+	require.Nil(t, m.Compact([]byte("key0"), []byte("key5")))
+	// empty range is a no-op
+	require.Nil(t, m.Compact([]byte("key5"), []byte("key0")))
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	require.Nil(t, m.Checkpoint(checkpoint))
+	require.NotNil(t, m.Checkpoint(checkpoint))
+	require.Nil(t, s.Close())
+
+	backup, err := New(checkpoint, nil, nil, testLogger)
+	require.Nil(t, err)
+	assert.Nil(t, backup.Get([]byte("key0")))
+	assert.EqualValues(t, []byte("key999"), backup.Get([]byte("key999")))
+	require.Nil(t, backup.Close())
+}
+
+func TestPdb_Collector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	open := func(name string) kv.Storage {
+		s, err := New(t.TempDir(), nil, nil, testLogger, WithCollector(registry, "axiom", "ledger", prometheus.Labels{"db": name}))
+		require.Nil(t, err)
+		return s
+	}
+
+	blockchain := open("blockchain")
+	state := open("state")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		state.Put([]byte(key), []byte(key))
+	}
+	require.Nil(t, state.(kv.Maintainer).Flush())
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	metrics := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		metrics[family.GetName()] = family
+	}
+	require.Contains(t, metrics, "axiom_ledger_kv_pebble_disk_space_usage_bytes")
+	require.Contains(t, metrics, "axiom_ledger_kv_pebble_block_cache_hit_rate")
+	require.Contains(t, metrics, "axiom_ledger_kv_pebble_write_stalls_total")
+	assert.Len(t, metrics["axiom_ledger_kv_pebble_compaction_debt_bytes"].GetMetric(), 2)
+	// 2 dbs with 7 levels each
+	levelFiles := metrics["axiom_ledger_kv_pebble_level_files"].GetMetric()
+	assert.Len(t, levelFiles, 14)
+	var files float64
+	for _, m := range levelFiles {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "db" && label.GetValue() == "state" {
+				files += m.GetGauge().GetValue()
+			}
+		}
+	}
+	assert.EqualValues(t, 1, files)
+
+	// the same labels are rejected until the db is closed
+	_, err = New(t.TempDir(), nil, nil, testLogger, WithCollector(registry, "axiom", "ledger", prometheus.Labels{"db": "state"}))
+	require.NotNil(t, err)
+	require.Nil(t, state.Close())
+	state = open("state")
+
+	require.Nil(t, state.Close())
+	require.Nil(t, blockchain.Close())
+	families, err = registry.Gather()
+	require.Nil(t, err)
+	assert.Empty(t, families)
+}
+
+func TestPdb_Conformance(t *testing.T) {
+	kv.ConformanceSuite(t, func() kv.Storage {
+		s, err := New(t.TempDir(), nil, nil, testLogger)
+		require.Nil(t, err)
+		return s
+	})
+}
+
+func TestPdb_Encrypted(t *testing.T) {
+	keys := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}
+	newEncrypted := func() kv.Storage {
+		db, err := New(t.TempDir(), nil, nil, testLogger)
+		require.Nil(t, err)
+		s, err := kv.NewEncrypted(db, kv.NewStaticKeyProvider(1, keys))
+		require.Nil(t, err)
+		return s
+	}
+	kv.ConformanceSuite(t, newEncrypted)
+	kv.SmokeBenchKvSuite(t, newEncrypted)
+}
+
 func BenchmarkPebbleSuite(b *testing.B) {
 	// Two memory tables is configured which is identical to leveldb,
 	// including a frozen memory table and another live one.
@@ -12,7 +12,9 @@ type Storage interface {
 	Write
 
 	// Get retrieves the object `value` named by `key`.
-	// Get will return nil if the key is not mapped to a value.
+	// Get will return nil if the key is not mapped to a value,
+	// and a non-nil empty slice if the key is mapped to an empty value.
+	// The returned slice is owned by the caller.
 	Get(key []byte) []byte
 
 	// Has returns whether the `key` is mapped to a `value`.
@@ -20,6 +22,7 @@ type Storage interface {
 
 	// Iterator iterates over a DB's key/value pairs in key order that
 	// range from the given start (including) and end (excluding).
+	// A nil start or end means the range is unbounded on that side.
 	// NOTICE: The returned Iterator is not positioned.
 	Iterator(start, end []byte) Iterator
 
@@ -36,20 +39,46 @@ type Storage interface {
 // Write is the write-side of the storage interface.
 type Write interface {
 	// Put stores the object `value` named by `key`.
+	// A nil value is stored as an empty value.
 	Put(key, value []byte)
 
 	// Delete removes the value for given `key`.
 	Delete(key []byte)
 }
 
+// Maintainer is the optional maintenance side of the storage interface,
+// implemented by backends that support online compaction and backup.
+// Callers should type-assert a Storage to Maintainer before use.
+type Maintainer interface {
+	// Compact compacts the underlying data for the key range [start, end).
+	// A nil start means the first key, and a nil end means the last key.
+	Compact(start, end []byte) error
+
+	// Checkpoint writes a consistent online snapshot of the DB to dir.
+	// Files are hard-linked where possible, so dir should live on the
+	// same filesystem as the DB. dir must not exist.
+	Checkpoint(dir string) error
+
+	// Flush flushes the in-memory data to disk.
+	Flush() error
+
+	// EstimateDiskUsage returns the estimated on-disk size in bytes
+	// of the data in the key range [start, end).
+	EstimateDiskUsage(start, end []byte) (uint64, error)
+}
+
 type Iterator interface {
 	// Next moves the iterator to the next key/value pair.
 	// It returns true if the next position is valid.
 	// It returns false if the iterator is exhausted.
+	// Next on a not positioned iterator, or an iterator exhausted by Prev,
+	// moves it to the first key/value pair.
 	Next() bool
 
 	// Prev moves the iterator to the previous key/value pair.
 	// It returns false if the iterator is exhausted.
+	// Prev on a not positioned iterator returns false, and Prev on an
+	// iterator exhausted by Next moves it to the last key/value pair.
 	Prev() bool
 
 	// Seek moves the iterator to the first key/value pair whose key is greater
@@ -59,10 +88,14 @@ type Iterator interface {
 	// It is safe to modify the contents of the argument after Seek returns.
 	Seek(key []byte) bool
 
-	// Key returns the key of the current key/value pair, or nil if done.
+	// Key returns the key of the current key/value pair, or nil if done
+	// or not positioned.
 	Key() []byte
 
-	// Value returns the value of the current key/value pair, or nil if done.
+	// Value returns the value of the current key/value pair, or nil if done
+	// or not positioned. An empty value is returned as a non-nil empty slice.
+	// The caller should not modify the contents of the returned slice, and
+	// its contents may change on the next call to any moving method.
 	Value() []byte
 }
 
@@ -71,11 +104,13 @@ type Batch interface {
 
 	Delete(key []byte)
 
+	// Commit atomically applies the batch, the batch is invisible to reads before.
 	Commit()
 
-	// Size returns the size of data in batch.
+	// Size returns the size of data in batch, that is the total length of
+	// the keys and values put, plus the keys deleted. It is kept after Commit.
 	Size() int
 
-	// Reset resets the batch for reuse.
+	// Reset discards the batch content for reuse.
 	Reset()
 }
@@ -5,85 +5,124 @@ import (
 	"crypto/rand"
 	"sort"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 // BenchKvSuite runs a suite of benchmarks against a KV backend implementation.
 func BenchKvSuite(b *testing.B, New func() Storage) {
-	var (
-		keys, vals   = makeDataset(1_000_000, 32, 32, false)
-		sKeys, sVals = makeDataset(1_000_000, 32, 32, true)
-	)
+	for _, group := range kvBenchmarks(New, 1_000_000) {
+		group := group
+		// Run benchmarks sequentially
+		b.Run(group.name, func(b *testing.B) {
+			for _, bench := range group.benchmarks {
+				bench := bench
+				b.Run(bench.name, func(b *testing.B) {
+					bench.run(b)
+				})
+			}
+		})
+	}
+}
 
-	// Run benchmarks sequentially
-	b.Run("Write", func(b *testing.B) {
-		benchWrite := func(b *testing.B, keys, vals [][]byte) {
-			b.ResetTimer()
-			b.ReportAllocs()
+// SmokeBenchKvSuite runs every benchmark of BenchKvSuite once on a small dataset,
+// so that go test checks the backend through them.
+func SmokeBenchKvSuite(t *testing.T, New func() Storage) {
+	for _, group := range kvBenchmarks(New, 1000) {
+		for _, bench := range group.benchmarks {
+			bench := bench
+			t.Run(group.name+"/"+bench.name, func(t *testing.T) {
+				bench.run(nopBenchTimer{})
+			})
+		}
+	}
+}
 
-			db := New()
-			defer db.Close()
+// benchTimer is the part of testing.B used by the benchmarks, so that they also run as tests.
+type benchTimer interface {
+	ResetTimer()
+	ReportAllocs()
+}
 
-			for i := 0; i < len(keys); i++ {
-				db.Put(keys[i], vals[i])
-			}
-		}
-		b.Run("WriteSorted", func(b *testing.B) {
-			benchWrite(b, sKeys, sVals)
-		})
+type nopBenchTimer struct{}
 
-		b.Run("WriteRandom", func(b *testing.B) {
-			benchWrite(b, keys, vals)
-		})
-	})
+func (nopBenchTimer) ResetTimer() {}
 
-	b.Run("BatchWrite", func(b *testing.B) {
-		benchBatchWrite := func(b *testing.B, keys, vals [][]byte) {
-			b.ResetTimer()
-			b.ReportAllocs()
+func (nopBenchTimer) ReportAllocs() {}
 
-			db := New()
-			defer db.Close()
+type kvBenchmark struct {
+	name string
+	run  func(b benchTimer)
+}
 
-			batch := db.NewBatch()
-			for i := 0; i < len(keys); i++ {
-				batch.Put(keys[i], vals[i])
-			}
-			batch.Commit()
+type kvBenchmarkGroup struct {
+	name       string
+	benchmarks []kvBenchmark
+}
+
+// kvBenchmarks returns the benchmarks of BenchKvSuite over datasets of the given size.
+func kvBenchmarks(New func() Storage, size int) []kvBenchmarkGroup {
+	var (
+		keys, vals   = makeDataset(size, 32, 32, false)
+		sKeys, sVals = makeDataset(size, 32, 32, true)
+	)
+
+	benchWrite := func(b benchTimer, keys, vals [][]byte) {
+		b.ResetTimer()
+		b.ReportAllocs()
+
+		db := New()
+		defer db.Close()
+
+		for i := 0; i < len(keys); i++ {
+			db.Put(keys[i], vals[i])
 		}
-		b.Run("BenchWriteSorted", func(b *testing.B) {
-			benchBatchWrite(b, sKeys, sVals)
-		})
-		b.Run("BenchWriteRandom", func(b *testing.B) {
-			benchBatchWrite(b, keys, vals)
-		})
-	})
+	}
+	benchBatchWrite := func(b benchTimer, keys, vals [][]byte) {
+		b.ResetTimer()
+		b.ReportAllocs()
 
-	b.Run("Read", func(b *testing.B) {
-		benchRead := func(b *testing.B, keys, vals [][]byte) {
-			db := New()
-			defer db.Close()
+		db := New()
+		defer db.Close()
 
-			batch := db.NewBatch()
-			for i := 0; i < len(keys); i++ {
-				batch.Put(keys[i], vals[i])
-			}
-			batch.Commit()
+		batch := db.NewBatch()
+		for i := 0; i < len(keys); i++ {
+			batch.Put(keys[i], vals[i])
+		}
+		batch.Commit()
+	}
+	benchRead := func(b benchTimer, keys, vals [][]byte) {
+		db := New()
+		defer db.Close()
 
-			b.ResetTimer()
-			b.ReportAllocs()
+		batch := db.NewBatch()
+		for i := 0; i < len(keys); i++ {
+			batch.Put(keys[i], vals[i])
+		}
+		batch.Commit()
 
-			for i := 0; i < len(keys); i++ {
-				db.Get(keys[i])
-			}
+		b.ResetTimer()
+		b.ReportAllocs()
+
+		for i := 0; i < len(keys); i++ {
+			db.Get(keys[i])
 		}
-		b.Run("ReadSorted", func(b *testing.B) {
-			benchRead(b, sKeys, sVals)
-		})
+	}
 
-		b.Run("ReadRandom", func(b *testing.B) {
-			benchRead(b, keys, vals)
-		})
-	})
+	return []kvBenchmarkGroup{
+		{name: "Write", benchmarks: []kvBenchmark{
+			{name: "WriteSorted", run: func(b benchTimer) { benchWrite(b, sKeys, sVals) }},
+			{name: "WriteRandom", run: func(b benchTimer) { benchWrite(b, keys, vals) }},
+		}},
+		{name: "BatchWrite", benchmarks: []kvBenchmark{
+			{name: "BenchWriteSorted", run: func(b benchTimer) { benchBatchWrite(b, sKeys, sVals) }},
+			{name: "BenchWriteRandom", run: func(b benchTimer) { benchBatchWrite(b, keys, vals) }},
+		}},
+		{name: "Read", benchmarks: []kvBenchmark{
+			{name: "ReadSorted", run: func(b benchTimer) { benchRead(b, sKeys, sVals) }},
+			{name: "ReadRandom", run: func(b benchTimer) { benchRead(b, keys, vals) }},
+		}},
+	}
 }
 
 func makeDataset(size, ksize, vsize int, order bool) ([][]byte, [][]byte) {
@@ -109,3 +148,239 @@ func randBytes(len int) []byte {
 	}
 	return buf
 }
+
+// ConformanceSuite runs a suite of tests checking that a KV backend implementation
+// follows the documented semantics of Storage, Iterator and Batch.
+// New must return an empty Storage on every call.
+func ConformanceSuite(t *testing.T, New func() Storage) {
+	open := func(t *testing.T, keys ...string) Storage {
+		db := New()
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		for _, key := range keys {
+			db.Put([]byte(key), []byte("v"+key))
+		}
+		return db
+	}
+	collect := func(t *testing.T, it Iterator) []string {
+		var keys []string
+		for it.Next() {
+			require.EqualValues(t, "v"+string(it.Key()), string(it.Value()))
+			keys = append(keys, string(it.Key()))
+		}
+		return keys
+	}
+
+	t.Run("GetPutDelete", func(t *testing.T) {
+		db := open(t)
+		require.Nil(t, db.Get([]byte("key")))
+		require.False(t, db.Has([]byte("key")))
+
+		db.Put([]byte("key"), []byte("value"))
+		require.EqualValues(t, []byte("value"), db.Get([]byte("key")))
+		require.True(t, db.Has([]byte("key")))
+
+		db.Put([]byte("key"), []byte("value2"))
+		require.EqualValues(t, []byte("value2"), db.Get([]byte("key")))
+
+		db.Delete([]byte("key"))
+		require.Nil(t, db.Get([]byte("key")))
+		require.False(t, db.Has([]byte("key")))
+
+		// deleting a missing key is a no-op
+		db.Delete([]byte("key"))
+	})
+
+	t.Run("GetReturnsCopy", func(t *testing.T) {
+		db := open(t)
+		key, value := []byte("key"), []byte("value")
+		db.Put(key, value)
+		key[0], value[0] = 'x', 'x'
+		require.EqualValues(t, []byte("value"), db.Get([]byte("key")))
+
+		got := db.Get([]byte("key"))
+		got[0] = 'x'
+		require.EqualValues(t, []byte("value"), db.Get([]byte("key")))
+	})
+
+	t.Run("EmptyValue", func(t *testing.T) {
+		db := open(t)
+		// empty and nil values are stored as empty values, which are distinguishable from missing keys
+		db.Put([]byte("empty"), []byte{})
+		db.Put([]byte("nil"), nil)
+		batch := db.NewBatch()
+		batch.Put([]byte("batch"), nil)
+		batch.Commit()
+
+		for _, key := range []string{"batch", "empty", "nil"} {
+			require.True(t, db.Has([]byte(key)), key)
+			require.NotNil(t, db.Get([]byte(key)), key)
+			require.Empty(t, db.Get([]byte(key)), key)
+		}
+
+		it := db.Iterator(nil, nil)
+		for _, key := range []string{"batch", "empty", "nil"} {
+			require.True(t, it.Next())
+			require.EqualValues(t, key, string(it.Key()))
+			require.NotNil(t, it.Value(), key)
+			require.Empty(t, it.Value(), key)
+		}
+		require.False(t, it.Next())
+	})
+
+	t.Run("Iterator", func(t *testing.T) {
+		db := open(t, "a", "b", "c", "d", "e")
+		require.EqualValues(t, []string{"a", "b", "c", "d", "e"}, collect(t, db.Iterator(nil, nil)))
+		require.EqualValues(t, []string{"b", "c", "d"}, collect(t, db.Iterator([]byte("b"), []byte("e"))))
+		require.EqualValues(t, []string{"b", "c", "d", "e"}, collect(t, db.Iterator([]byte("az"), nil)))
+		require.EqualValues(t, []string{"a", "b"}, collect(t, db.Iterator(nil, []byte("bz"))))
+		require.Empty(t, collect(t, db.Iterator([]byte("f"), nil)))
+		require.Empty(t, collect(t, db.Iterator([]byte("c"), []byte("c"))))
+		require.Empty(t, collect(t, open(t).Iterator(nil, nil)))
+	})
+
+	t.Run("IteratorNotPositioned", func(t *testing.T) {
+		db := open(t, "a", "b")
+		it := db.Iterator(nil, nil)
+		require.Nil(t, it.Key())
+		require.Nil(t, it.Value())
+		require.True(t, it.Next())
+		require.EqualValues(t, "a", string(it.Key()))
+
+		it = db.Iterator(nil, nil)
+		require.False(t, it.Prev())
+		require.Nil(t, it.Key())
+		require.True(t, it.Next())
+		require.EqualValues(t, "a", string(it.Key()))
+	})
+
+	t.Run("IteratorExhausted", func(t *testing.T) {
+		db := open(t, "a", "b", "c")
+		it := db.Iterator(nil, nil)
+		for it.Next() {
+		}
+		require.Nil(t, it.Key())
+		require.Nil(t, it.Value())
+		require.False(t, it.Next())
+		// Prev after exhausting forward moves to the last pair
+		require.True(t, it.Prev())
+		require.EqualValues(t, "c", string(it.Key()))
+		require.EqualValues(t, "vc", string(it.Value()))
+
+		for it.Prev() {
+		}
+		require.Nil(t, it.Key())
+		require.Nil(t, it.Value())
+		require.False(t, it.Prev())
+		// Next after exhausting backward moves to the first pair
+		require.True(t, it.Next())
+		require.EqualValues(t, "a", string(it.Key()))
+	})
+
+	t.Run("Prev", func(t *testing.T) {
+		db := open(t, "a", "b", "c", "d")
+		it := db.Iterator([]byte("b"), nil)
+		require.True(t, it.Next())
+		require.True(t, it.Next())
+		require.EqualValues(t, "c", string(it.Key()))
+		require.True(t, it.Prev())
+		require.EqualValues(t, "b", string(it.Key()))
+		require.False(t, it.Prev())
+		require.True(t, it.Next())
+		require.EqualValues(t, "b", string(it.Key()))
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		db := open(t, "a", "c", "e", "g")
+		it := db.Iterator([]byte("b"), []byte("g"))
+		require.True(t, it.Seek([]byte("c")))
+		require.EqualValues(t, "c", string(it.Key()))
+		require.EqualValues(t, "vc", string(it.Value()))
+		require.True(t, it.Next())
+		require.EqualValues(t, "e", string(it.Key()))
+
+		// seek moves to the next greater key
+		require.True(t, it.Seek([]byte("d")))
+		require.EqualValues(t, "e", string(it.Key()))
+		require.True(t, it.Prev())
+		require.EqualValues(t, "c", string(it.Key()))
+
+		// seek is clamped by the iterator range
+		require.True(t, it.Seek([]byte("a")))
+		require.EqualValues(t, "c", string(it.Key()))
+		require.False(t, it.Seek([]byte("f")))
+		require.Nil(t, it.Key())
+		require.True(t, it.Prev())
+		require.EqualValues(t, "e", string(it.Key()))
+
+		// the seek key may be modified after Seek returns
+		key := []byte("c")
+		require.True(t, it.Seek(key))
+		key[0] = 'x'
+		require.EqualValues(t, "c", string(it.Key()))
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		db := open(t, "a", "key", "key1", "key10", "key2", "kez", "\xff", "\xff\xff", "\xff\xff\x01")
+		require.EqualValues(t, []string{"key", "key1", "key10", "key2"}, collect(t, db.Prefix([]byte("key"))))
+		require.EqualValues(t, []string{"key1", "key10"}, collect(t, db.Prefix([]byte("key1"))))
+		require.EqualValues(t, []string{"\xff\xff", "\xff\xff\x01"}, collect(t, db.Prefix([]byte("\xff\xff"))))
+		require.Len(t, collect(t, db.Prefix(nil)), 9)
+		require.Empty(t, collect(t, db.Prefix([]byte("none"))))
+
+		it := db.Prefix([]byte("key1"))
+		require.False(t, it.Seek([]byte("key2")))
+		require.True(t, it.Seek([]byte("a")))
+		require.EqualValues(t, "key1", string(it.Key()))
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		db := open(t, "a", "b")
+		batch := db.NewBatch()
+		batch.Put([]byte("c"), []byte("vc"))
+		batch.Delete([]byte("a"))
+		batch.Put([]byte("d"), []byte("x"))
+		batch.Put([]byte("d"), []byte("vd"))
+		// a batch is invisible until committed
+		require.True(t, db.Has([]byte("a")))
+		require.False(t, db.Has([]byte("c")))
+		batch.Commit()
+		require.EqualValues(t, []string{"b", "c", "d"}, collect(t, db.Iterator(nil, nil)))
+	})
+
+	t.Run("BatchSize", func(t *testing.T) {
+		db := open(t)
+		batch := db.NewBatch()
+		require.EqualValues(t, 0, batch.Size())
+		batch.Put([]byte("key"), []byte("value"))
+		require.EqualValues(t, 8, batch.Size())
+		batch.Delete([]byte("key"))
+		require.EqualValues(t, 11, batch.Size())
+		batch.Put([]byte("empty"), nil)
+		require.EqualValues(t, 16, batch.Size())
+		// size is kept after commit
+		batch.Commit()
+		require.EqualValues(t, 16, batch.Size())
+	})
+
+	t.Run("BatchReset", func(t *testing.T) {
+		db := open(t)
+		batch := db.NewBatch()
+		batch.Put([]byte("discarded"), []byte("value"))
+		batch.Reset()
+		require.EqualValues(t, 0, batch.Size())
+		batch.Put([]byte("a"), []byte("va"))
+		batch.Commit()
+		require.False(t, db.Has([]byte("discarded")))
+		require.EqualValues(t, []string{"a"}, collect(t, db.Iterator(nil, nil)))
+
+		// a reset batch can be reused after commit
+		batch.Reset()
+		require.EqualValues(t, 0, batch.Size())
+		batch.Put([]byte("b"), []byte("vb"))
+		batch.Delete([]byte("a"))
+		batch.Commit()
+		require.EqualValues(t, []string{"b"}, collect(t, db.Iterator(nil, nil)))
+	})
+}
@@ -0,0 +1,323 @@
+package kv
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	ttlDataPrefix  byte = 'd'
+	ttlIndexPrefix byte = 'e'
+
+	// expiry unix nano(8), 0 means never
+	ttlExpirySize = 8
+
+	defaultSweepInterval = time.Minute
+	sweepBatchSize       = 1000
+)
+
+// TTLStorage is a Storage whose keys may expire.
+type TTLStorage interface {
+	Storage
+
+	// PutWithTTL stores the object `value` named by `key`, which expires after ttl.
+	PutWithTTL(key, value []byte, ttl time.Duration)
+
+	// Sweep deletes the expired keys and returns the number of deleted keys.
+	Sweep() int
+}
+
+// TTLBatch is the Batch of a TTLStorage.
+type TTLBatch interface {
+	Batch
+
+	PutWithTTL(key, value []byte, ttl time.Duration)
+}
+
+type TTLOption func(t *ttlStorage)
+
+// WithSweepInterval sets the interval of the background sweeper, defaults to 1 minute.
+// A non-positive interval disables the background sweeper.
+func WithSweepInterval(interval time.Duration) TTLOption {
+	return func(t *ttlStorage) {
+		t.sweepInterval = interval
+	}
+}
+
+type ttlStorage struct {
+	db  Storage
+	now func() time.Time
+
+	// writes hold the read lock, so that the sweeper never deletes a renewed key
+	lock sync.RWMutex
+
+	sweepInterval time.Duration
+	closeOnce     sync.Once
+	closeC        chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewTTL wraps db so that keys may expire. Every value is stored with its expiry,
+// and keys with an expiry are also indexed by expiry so that the sweeper finds the
+// expired keys by range iteration. Expired keys are invisible before they are swept.
+// The wrapper owns the whole key space of db.
+func NewTTL(db Storage, opts ...TTLOption) TTLStorage {
+	t := &ttlStorage{
+		db:            db,
+		now:           time.Now,
+		sweepInterval: defaultSweepInterval,
+		closeC:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.sweepInterval > 0 {
+		t.wg.Add(1)
+		go t.sweepLoop()
+	}
+	return t
+}
+
+func ttlDataKey(key []byte) []byte {
+	return append([]byte{ttlDataPrefix}, key...)
+}
+
+// ttlIndexKey encodes the index key as prefix(1) | expiry(8) | key.
+func ttlIndexKey(expiry uint64, key []byte) []byte {
+	indexKey := make([]byte, 1+ttlExpirySize, 1+ttlExpirySize+len(key))
+	indexKey[0] = ttlIndexPrefix
+	binary.BigEndian.PutUint64(indexKey[1:], expiry)
+	return append(indexKey, key...)
+}
+
+func ttlEncode(expiry uint64, value []byte) []byte {
+	encoded := make([]byte, ttlExpirySize+len(value))
+	binary.BigEndian.PutUint64(encoded, expiry)
+	copy(encoded[ttlExpirySize:], value)
+	return encoded
+}
+
+func (t *ttlStorage) expiry(ttl time.Duration) uint64 {
+	return uint64(t.now().Add(ttl).UnixNano())
+}
+
+// live returns the value of the encoded one, or nil if it is missing or expired.
+func (t *ttlStorage) live(encoded []byte) []byte {
+	if encoded == nil {
+		return nil
+	}
+	if expiry := binary.BigEndian.Uint64(encoded); expiry != 0 && expiry <= uint64(t.now().UnixNano()) {
+		return nil
+	}
+	return encoded[ttlExpirySize:]
+}
+
+func (t *ttlStorage) Put(key, value []byte) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	t.db.Put(ttlDataKey(key), ttlEncode(0, value))
+}
+
+func (t *ttlStorage) PutWithTTL(key, value []byte, ttl time.Duration) {
+	expiry := t.expiry(ttl)
+	batch := t.db.NewBatch()
+	batch.Put(ttlDataKey(key), ttlEncode(expiry, value))
+	batch.Put(ttlIndexKey(expiry, key), nil)
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	batch.Commit()
+}
+
+// Delete leaves the index entry of key, which is dropped by the sweeper.
+func (t *ttlStorage) Delete(key []byte) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	t.db.Delete(ttlDataKey(key))
+}
+
+func (t *ttlStorage) Get(key []byte) []byte {
+	return t.live(t.db.Get(ttlDataKey(key)))
+}
+
+func (t *ttlStorage) Has(key []byte) bool {
+	return t.Get(key) != nil
+}
+
+func (t *ttlStorage) Iterator(start, end []byte) Iterator {
+	var dataEnd []byte
+	if end == nil {
+		dataEnd = []byte{ttlDataPrefix + 1}
+	} else {
+		dataEnd = ttlDataKey(end)
+	}
+	return &ttlIterator{Iterator: t.db.Iterator(ttlDataKey(start), dataEnd), t: t}
+}
+
+func (t *ttlStorage) Prefix(prefix []byte) Iterator {
+	return &ttlIterator{Iterator: t.db.Prefix(ttlDataKey(prefix)), t: t}
+}
+
+func (t *ttlStorage) NewBatch() Batch {
+	return &ttlBatch{Batch: t.db.NewBatch(), t: t}
+}
+
+func (t *ttlStorage) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closeC)
+	})
+	t.wg.Wait()
+	return t.db.Close()
+}
+
+func (t *ttlStorage) sweepLoop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closeC:
+			return
+		case <-ticker.C:
+			t.Sweep()
+		}
+	}
+}
+
+func (t *ttlStorage) Sweep() int {
+	var (
+		swept   int
+		pending []ttlIndexEntry
+		it      = t.db.Iterator([]byte{ttlIndexPrefix}, ttlIndexKey(uint64(t.now().UnixNano())+1, nil))
+	)
+	for it.Next() {
+		indexKey := it.Key()
+		pending = append(pending, ttlIndexEntry{
+			expiry: binary.BigEndian.Uint64(indexKey[1:]),
+			key:    append([]byte{}, indexKey[1+ttlExpirySize:]...),
+		})
+		if len(pending) == sweepBatchSize {
+			swept += t.sweep(pending)
+			pending = pending[:0]
+		}
+	}
+	return swept + t.sweep(pending)
+}
+
+type ttlIndexEntry struct {
+	expiry uint64
+	key    []byte
+}
+
+// sweep deletes the given index entries, and the keys which still expire at the indexed expiry.
+func (t *ttlStorage) sweep(entries []ttlIndexEntry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+
+	// block the writers, the keys may be renewed once checked
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var (
+		swept int
+		batch = t.db.NewBatch()
+	)
+	for _, entry := range entries {
+		dataKey := ttlDataKey(entry.key)
+		if encoded := t.db.Get(dataKey); encoded != nil && binary.BigEndian.Uint64(encoded) == entry.expiry {
+			batch.Delete(dataKey)
+			swept++
+		}
+		batch.Delete(ttlIndexKey(entry.expiry, entry.key))
+	}
+	batch.Commit()
+	return swept
+}
+
+type ttlIterator struct {
+	Iterator
+	t *ttlStorage
+}
+
+func (it *ttlIterator) Next() bool {
+	for it.Iterator.Next() {
+		if it.t.live(it.Iterator.Value()) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *ttlIterator) Prev() bool {
+	for it.Iterator.Prev() {
+		if it.t.live(it.Iterator.Value()) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *ttlIterator) Seek(key []byte) bool {
+	if !it.Iterator.Seek(ttlDataKey(key)) {
+		return false
+	}
+	if it.t.live(it.Iterator.Value()) != nil {
+		return true
+	}
+	return it.Next()
+}
+
+func (it *ttlIterator) Key() []byte {
+	key := it.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+	return key[1:]
+}
+
+func (it *ttlIterator) Value() []byte {
+	encoded := it.Iterator.Value()
+	if encoded == nil {
+		return nil
+	}
+	return encoded[ttlExpirySize:]
+}
+
+type ttlBatch struct {
+	Batch
+	t    *ttlStorage
+	size int
+}
+
+func (b *ttlBatch) Put(key, value []byte) {
+	b.Batch.Put(ttlDataKey(key), ttlEncode(0, value))
+	b.size += len(key) + len(value)
+}
+
+func (b *ttlBatch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	expiry := b.t.expiry(ttl)
+	b.Batch.Put(ttlDataKey(key), ttlEncode(expiry, value))
+	b.Batch.Put(ttlIndexKey(expiry, key), nil)
+	b.size += len(key) + len(value)
+}
+
+func (b *ttlBatch) Delete(key []byte) {
+	b.Batch.Delete(ttlDataKey(key))
+	b.size += len(key)
+}
+
+func (b *ttlBatch) Commit() {
+	b.t.lock.RLock()
+	defer b.t.lock.RUnlock()
+	b.Batch.Commit()
+}
+
+func (b *ttlBatch) Size() int {
+	return b.size
+}
+
+func (b *ttlBatch) Reset() {
+	b.Batch.Reset()
+	b.size = 0
+}
@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestTTL() (*ttlStorage, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	t := NewTTL(NewMemory(), WithSweepInterval(0)).(*ttlStorage)
+	t.now = clock.Now
+	return t, clock
+}
+
+func TestTTL(t *testing.T) {
+	s, clock := newTestTTL()
+
+	s.Put([]byte("forever"), []byte("value"))
+	s.PutWithTTL([]byte("short"), []byte("value"), time.Second)
+	s.PutWithTTL([]byte("long"), []byte("value"), time.Hour)
+	assert.True(t, s.Has([]byte("short")))
+	assert.EqualValues(t, []byte("value"), s.Get([]byte("short")))
+
+	clock.now = clock.now.Add(time.Second)
+	// expired keys are invisible before they are swept
+	assert.False(t, s.Has([]byte("short")))
+	assert.Nil(t, s.Get([]byte("short")))
+	assert.True(t, s.Has([]byte("long")))
+
+	it := s.Iterator(nil, nil)
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+		assert.EqualValues(t, []byte("value"), it.Value())
+	}
+	assert.EqualValues(t, []string{"forever", "long"}, keys)
+
+	assert.EqualValues(t, 1, s.Sweep())
+	assert.Nil(t, s.db.Get(ttlDataKey([]byte("short"))))
+	assert.EqualValues(t, 0, s.Sweep())
+
+	clock.now = clock.now.Add(time.Hour)
+	assert.EqualValues(t, 1, s.Sweep())
+	assert.True(t, s.Has([]byte("forever")))
+	// only the data of the remaining key is left
+	assert.EqualValues(t, 1, countKeys(s.db))
+}
+
+func countKeys(db Storage) int {
+	cnt := 0
+	it := db.Iterator(nil, nil)
+	for it.Next() {
+		cnt++
+	}
+	return cnt
+}
+
+func TestTTL_Renew(t *testing.T) {
+	s, clock := newTestTTL()
+
+	s.PutWithTTL([]byte("renewed"), []byte("v1"), time.Second)
+	s.PutWithTTL([]byte("renewed"), []byte("v2"), time.Hour)
+	s.PutWithTTL([]byte("persisted"), []byte("v1"), time.Second)
+	s.Put([]byte("persisted"), []byte("v2"))
+	s.PutWithTTL([]byte("deleted"), []byte("v1"), time.Second)
+	s.Delete([]byte("deleted"))
+
+	clock.now = clock.now.Add(time.Minute)
+	// stale index entries are dropped without touching the data
+	assert.EqualValues(t, 0, s.Sweep())
+	assert.EqualValues(t, []byte("v2"), s.Get([]byte("renewed")))
+	assert.EqualValues(t, []byte("v2"), s.Get([]byte("persisted")))
+	assert.EqualValues(t, 3, countKeys(s.db))
+}
+
+func TestTTL_Batch(t *testing.T) {
+	s, clock := newTestTTL()
+
+	batch := s.NewBatch().(TTLBatch)
+	for i := 0; i < 2500; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		if i%2 == 0 {
+			batch.PutWithTTL([]byte(key), []byte(key), time.Second)
+		} else {
+			batch.Put([]byte(key), []byte(key))
+		}
+	}
+	assert.EqualValues(t, 2500*14, batch.Size())
+	batch.Commit()
+
+	clock.now = clock.now.Add(time.Second)
+	it := s.Prefix([]byte("key"))
+	assert.True(t, it.Seek([]byte("key0000")))
+	assert.EqualValues(t, "key0001", string(it.Key()))
+	assert.True(t, it.Prev() == false)
+
+	assert.EqualValues(t, 1250, s.Sweep())
+	assert.EqualValues(t, 1250, countKeys(s.db))
+}
+
+func TestTTL_SweepLoop(t *testing.T) {
+	s := NewTTL(NewMemory(), WithSweepInterval(10*time.Millisecond))
+	s.PutWithTTL([]byte("key"), []byte("value"), time.Millisecond)
+	require.Eventually(t, func() bool {
+		return countKeys(s.(*ttlStorage).db) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, s.Close())
+}
+
+func TestTTL_Conformance(t *testing.T) {
+	ConformanceSuite(t, func() Storage {
+		return NewTTL(NewMemory())
+	})
+}